@@ -0,0 +1,277 @@
+package amazonsqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/golangid/candi/candishared"
+	"github.com/golangid/candi/logger"
+	"github.com/golangid/candi/tracer"
+)
+
+const (
+	// maxBatchEntries max entries in single SendMessageBatch request
+	maxBatchEntries = 10
+	// maxBatchPayloadSize max total payload size (in bytes) of single SendMessageBatch request
+	maxBatchPayloadSize = 256 * 1024
+)
+
+var (
+	// ErrBatchPublisherClosed returned when publishing message after batch publisher closed
+	ErrBatchPublisherClosed = errors.New("amazonsqs: batch publisher is closed")
+)
+
+type (
+	// BatchPublisherOptionFunc func type
+	BatchPublisherOptionFunc func(*BatchPublisher)
+
+	// BatchErrorHandler func type, called for each message failed to publish in batch
+	BatchErrorHandler func(args *candishared.PublisherArgument, err error)
+
+	// BatchPublisher buffer messages per queue and publish with SendMessageBatch
+	BatchPublisher struct {
+		client        *sqs.Client
+		resolver      *queueResolver
+		flushInterval time.Duration
+		errorHandler  BatchErrorHandler
+
+		mu      sync.Mutex
+		buffers map[string]*batchBuffer
+		closed  bool
+
+		done      chan struct{}
+		closeOnce sync.Once
+	}
+
+	// batchBuffer pending entries and full batches of single queue, batches are sent in order
+	// and one at a time (sendMu) so message group ordering of FIFO queue is preserved
+	batchBuffer struct {
+		entries []batchEntry
+		size    int
+		ready   [][]batchEntry
+
+		sendMu sync.Mutex
+	}
+
+	batchEntry struct {
+		args  *candishared.PublisherArgument
+		input *sqs.SendMessageInput
+		size  int
+	}
+)
+
+// BatchPublisherSetFlushInterval set interval for flushing buffered messages, disable interval flush if zero
+func BatchPublisherSetFlushInterval(flushInterval time.Duration) BatchPublisherOptionFunc {
+	return func(p *BatchPublisher) {
+		p.flushInterval = flushInterval
+	}
+}
+
+// BatchPublisherSetErrorHandler set handler for each message failed to publish
+func BatchPublisherSetErrorHandler(errorHandler BatchErrorHandler) BatchPublisherOptionFunc {
+	return func(p *BatchPublisher) {
+		p.errorHandler = errorHandler
+	}
+}
+
+// NewBatchPublisher constructor, flush buffered messages when reach 10 messages or 256KB per queue and on every flush interval
+func NewBatchPublisher(client *sqs.Client, opts ...BatchPublisherOptionFunc) *BatchPublisher {
+	p := &BatchPublisher{
+		client:        client,
+		resolver:      newQueueResolver(client),
+		flushInterval: time.Second,
+		buffers:       make(map[string]*batchBuffer),
+		done:          make(chan struct{}),
+		errorHandler: func(args *candishared.PublisherArgument, err error) {
+			logger.LogRed(fmt.Sprintf("amazonsqs_batch_publisher > publish message to queue '%s': %s", args.Topic, err.Error()))
+		},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.flushInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(p.flushInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-p.done:
+					return
+				case <-ticker.C:
+					p.Flush(context.Background())
+				}
+			}
+		}()
+	}
+
+	return p
+}
+
+func (p *BatchPublisher) setQueueResolver(resolver *queueResolver) {
+	p.resolver = resolver
+}
+
+// PublishMessage method, buffer message and publish when batch is full
+func (p *BatchPublisher) PublishMessage(ctx context.Context, args *candishared.PublisherArgument) (err error) {
+	trace, ctx := tracer.StartTraceWithContext(ctx, "AmazonSQSBatchPublisher:PublishMessage")
+	defer func() { trace.Finish(tracer.FinishWithError(err)) }()
+
+	input, err := newSendMessageInput(ctx, trace, args)
+	if err != nil {
+		return err
+	}
+	entry := batchEntry{args: args, input: input}
+	entry.size = len(aws.ToString(entry.input.MessageBody))
+	for k, v := range entry.input.MessageAttributes {
+		entry.size += len(k) + len(aws.ToString(v.DataType)) + len(aws.ToString(v.StringValue)) + len(v.BinaryValue)
+	}
+	if entry.size > maxBatchPayloadSize {
+		return fmt.Errorf("message size %d bytes exceed max payload size %d bytes", entry.size, maxBatchPayloadSize)
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrBatchPublisherClosed
+	}
+	buff, ok := p.buffers[args.Topic]
+	if !ok {
+		buff = &batchBuffer{}
+		p.buffers[args.Topic] = buff
+	}
+	if buff.size+entry.size > maxBatchPayloadSize {
+		buff.cut()
+	}
+	buff.entries = append(buff.entries, entry)
+	buff.size += entry.size
+	if len(buff.entries) >= maxBatchEntries {
+		buff.cut()
+	}
+	hasReady := len(buff.ready) > 0
+	p.mu.Unlock()
+
+	if !hasReady {
+		return nil
+	}
+	return p.sendReady(ctx, args.Topic, buff)
+}
+
+// Flush publish all buffered messages
+func (p *BatchPublisher) Flush(ctx context.Context) error {
+	p.mu.Lock()
+	buffers := make(map[string]*batchBuffer, len(p.buffers))
+	for queue, buff := range p.buffers {
+		buff.cut()
+		if len(buff.ready) > 0 {
+			buffers[queue] = buff
+		}
+	}
+	p.mu.Unlock()
+
+	var errs []error
+	for queue, buff := range buffers {
+		if err := p.sendReady(ctx, queue, buff); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stop interval flush and publish all remaining buffered messages,
+// PublishMessage return ErrBatchPublisherClosed after closed
+func (p *BatchPublisher) Close(ctx context.Context) error {
+	p.closeOnce.Do(func() {
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+		close(p.done)
+	})
+	return p.Flush(ctx)
+}
+
+// sendReady send full batches of queue in order, batches cut by concurrent publish while waiting
+// previous send are sent by this call too
+func (p *BatchPublisher) sendReady(ctx context.Context, queue string, buff *batchBuffer) error {
+	buff.sendMu.Lock()
+	defer buff.sendMu.Unlock()
+
+	var errs []error
+	for {
+		p.mu.Lock()
+		if len(buff.ready) == 0 {
+			p.mu.Unlock()
+			return errors.Join(errs...)
+		}
+		entries := buff.ready[0]
+		buff.ready = buff.ready[1:]
+		p.mu.Unlock()
+
+		if err := p.sendBatch(ctx, queue, entries); err != nil {
+			errs = append(errs, err)
+		}
+	}
+}
+
+func (p *BatchPublisher) sendBatch(ctx context.Context, queue string, entries []batchEntry) (err error) {
+	trace, ctx := tracer.StartTraceWithContext(ctx, "AmazonSQSBatchPublisher:SendMessageBatch")
+	defer func() { trace.Finish(tracer.FinishWithError(err)) }()
+
+	trace.SetTag("topic", queue)
+	trace.SetTag("count", len(entries))
+
+	queueURL, err := p.resolver.resolve(ctx, queue)
+	if err != nil {
+		for _, entry := range entries {
+			p.errorHandler(entry.args, err)
+		}
+		return err
+	}
+
+	input := &sqs.SendMessageBatchInput{QueueUrl: aws.String(queueURL)}
+	for i, entry := range entries {
+		input.Entries = append(input.Entries, sqstypes.SendMessageBatchRequestEntry{
+			Id:                     aws.String(strconv.Itoa(i)),
+			MessageBody:            entry.input.MessageBody,
+			MessageAttributes:      entry.input.MessageAttributes,
+			MessageGroupId:         entry.input.MessageGroupId,
+			MessageDeduplicationId: entry.input.MessageDeduplicationId,
+		})
+	}
+
+	result, err := p.client.SendMessageBatch(ctx, input)
+	if err != nil {
+		for _, entry := range entries {
+			p.errorHandler(entry.args, err)
+		}
+		return err
+	}
+
+	for _, failed := range result.Failed {
+		idx, _ := strconv.Atoi(aws.ToString(failed.Id))
+		if idx < 0 || idx >= len(entries) {
+			continue
+		}
+		p.errorHandler(entries[idx].args, fmt.Errorf("%s: %s", aws.ToString(failed.Code), aws.ToString(failed.Message)))
+	}
+	if len(result.Failed) > 0 {
+		err = fmt.Errorf("failed to publish %d of %d messages to queue '%s'", len(result.Failed), len(entries), queue)
+	}
+	return err
+}
+
+// cut move pending entries to ready batches
+func (b *batchBuffer) cut() {
+	if len(b.entries) == 0 {
+		return
+	}
+	b.ready = append(b.ready, b.entries)
+	b.entries, b.size = nil, 0
+}
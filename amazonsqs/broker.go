@@ -2,8 +2,15 @@ package amazonsqs
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/golangid/candi/codebase/factory/types"
 	"github.com/golangid/candi/codebase/interfaces"
 	"github.com/golangid/candi/logger"
@@ -26,6 +33,22 @@ func BrokerSetPublisher(pub interfaces.Publisher) BrokerOptionFunc {
 	}
 }
 
+// BrokerSetAutoCreateQueue create missing queue at worker startup with default attributes (e.g. RedrivePolicy),
+// attributes can be overridden per handler with ConfigQueueAttributes
+func BrokerSetAutoCreateQueue(attributes map[string]string) BrokerOptionFunc {
+	return func(bk *Broker) {
+		bk.autoCreateQueue = true
+		bk.queueAttributes = attributes
+	}
+}
+
+// BrokerSetHealthCheckTimeout set timeout for checking queues in health method
+func BrokerSetHealthCheckTimeout(timeout time.Duration) BrokerOptionFunc {
+	return func(bk *Broker) {
+		bk.healthCheckTimeout = timeout
+	}
+}
+
 // InitDefaultConnection amazonsqs
 func InitDefaultConnection(options ...func(*config.LoadOptions) error) *sqs.Client {
 	// Load AWS configuration with the provided options
@@ -43,16 +66,22 @@ func NewAmazonSQSBroker(client *sqs.Client, opts ...BrokerOptionFunc) *Broker {
 	defer deferFunc()
 
 	amazonSQSBroker := &Broker{
-		WorkerType: AmazonSQSBroker,
-		Client:     client,
+		WorkerType:         AmazonSQSBroker,
+		Client:             client,
+		healthCheckTimeout: 5 * time.Second,
 	}
 	for _, opt := range opts {
 		opt(amazonSQSBroker)
 	}
 
+	amazonSQSBroker.resolver = newQueueResolver(amazonSQSBroker.Client)
 	if amazonSQSBroker.publisher == nil {
 		amazonSQSBroker.publisher = NewPublisher(amazonSQSBroker.Client)
 	}
+	// share queue url cache with publisher from this package
+	if pub, ok := amazonSQSBroker.publisher.(interface{ setQueueResolver(*queueResolver) }); ok {
+		pub.setQueueResolver(amazonSQSBroker.resolver)
+	}
 
 	return amazonSQSBroker
 }
@@ -62,6 +91,22 @@ type Broker struct {
 	WorkerType types.Worker
 	Client     *sqs.Client
 	publisher  interfaces.Publisher
+
+	resolver           *queueResolver
+	autoCreateQueue    bool
+	queueAttributes    map[string]string
+	healthCheckTimeout time.Duration
+
+	mu           sync.Mutex
+	workerQueues map[string]string
+}
+
+// maxHealthCheckConcurrency max concurrent queue check in health method
+const maxHealthCheckConcurrency = 5
+
+// GetQueueURL resolve queue url from queue name or url
+func (s *Broker) GetQueueURL(ctx context.Context, queue string) (string, error) {
+	return s.resolver.resolve(ctx, queue)
 }
 
 // GetPublisher method
@@ -74,20 +119,74 @@ func (s *Broker) GetName() types.Worker {
 	return AmazonSQSBroker
 }
 
-// Health method
+// Health method, check queues registered by worker (or list queues if no worker registered) to verify credentials and connectivity
 func (s *Broker) Health() map[string]error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.healthCheckTimeout)
+	defer cancel()
 
-	// TODO: add health check from client connection
-	var err error
 	return map[string]error{
-		string(AmazonSQSBroker): err,
+		string(AmazonSQSBroker): s.checkQueues(ctx),
 	}
 }
 
+func (s *Broker) checkQueues(ctx context.Context) error {
+	s.mu.Lock()
+	queueURLs := make(map[string]string, len(s.workerQueues))
+	for queue, queueURL := range s.workerQueues {
+		queueURLs[queue] = queueURL
+	}
+	s.mu.Unlock()
+
+	if len(queueURLs) == 0 {
+		_, err := s.Client.ListQueues(ctx, &sqs.ListQueuesInput{MaxResults: aws.Int32(1)})
+		return err
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		errs      []error
+		semaphore = make(chan struct{}, maxHealthCheckConcurrency)
+	)
+	for queue, queueURL := range queueURLs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(queue, queueURL string) {
+			defer func() { <-semaphore; wg.Done() }()
+			_, err := s.Client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+				QueueUrl:       aws.String(queueURL),
+				AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameQueueArn},
+			})
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("queue '%s': %w", queue, err))
+				mu.Unlock()
+			}
+		}(queue, queueURL)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// registerWorkerQueue register queue consumed by worker for health check
+func (s *Broker) registerWorkerQueue(queue, queueURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.workerQueues == nil {
+		s.workerQueues = make(map[string]string)
+	}
+	s.workerQueues[queue] = queueURL
+}
+
 // Disconnect method
 func (s *Broker) Disconnect(ctx context.Context) error {
 	deferFunc := logger.LogWithDefer("amazonsqs broker: disconnect...")
 	defer deferFunc()
 
+	// stop and drain pending messages if using batch publisher
+	if closer, ok := s.publisher.(interface{ Close(context.Context) error }); ok {
+		return closer.Close(ctx)
+	}
 	return nil
 }
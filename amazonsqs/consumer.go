@@ -3,50 +3,77 @@ package amazonsqs
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/golangid/candi/candishared"
 	"github.com/golangid/candi/codebase/factory"
 	"github.com/golangid/candi/codebase/factory/types"
 	"github.com/golangid/candi/codebase/interfaces"
 	"github.com/golangid/candi/logger"
 	"github.com/golangid/candi/tracer"
-	"log"
-	"reflect"
-	"sync"
+)
+
+const (
+	receiveErrorMinBackoff = 1 * time.Second
+	receiveErrorMaxBackoff = 30 * time.Second
+
+	// maxVisibilityTimeout maximum visibility timeout allowed by Amazon SQS
+	maxVisibilityTimeout = 12 * time.Hour
 )
 
 type workerEngine struct {
 	ctx           context.Context
 	ctxCancelFunc func()
 
-	service    factory.ServiceFactory
-	channels   []reflect.SelectCase
-	semaphore  map[string]chan struct{}
-	shutdown   chan struct{}
-	isShutdown bool
-	wg         sync.WaitGroup
-	opt        option
+	// receiverCtx only control the long-polling loop, handler keep using ctx until all running jobs done
+	receiverCtx        context.Context
+	receiverCancelFunc func()
+	receiverWg         sync.WaitGroup
+
+	service   factory.ServiceFactory
+	semaphore map[string]chan struct{}
+	shutdown  chan struct{}
+	wg        sync.WaitGroup
+	opt       option
 
-	bk          *Broker
-	subscribers map[string]*sqs.ReceiveMessageOutput
-	queueUrl    map[string]*sqs.GetQueueUrlOutput
-	handlers    map[string]types.WorkerHandler
+	bk       *Broker
+	queueUrl map[string]*string
+	handlers map[string]types.WorkerHandler
 }
 
 // NewAmazonSQSWorker create new amazonsqs client worker for subscribe from queue
-func NewAmazonSQSWorker(service factory.ServiceFactory, broker interfaces.Broker, maxNumberMessage int, waitTimeSeconds int) factory.AppServerFactory {
+func NewAmazonSQSWorker(service factory.ServiceFactory, broker interfaces.Broker, opts ...OptionFunc) factory.AppServerFactory {
 	amazonSQSBk, ok := broker.(*Broker)
 	if !ok {
 		panic("Missing Amazon SQS broker, make sure Amazon SQS has been registered to broker in service config")
 	}
 
-	worker := new(workerEngine)
-	worker.ctx, worker.ctxCancelFunc = context.WithCancel(context.Background())
+	worker := &workerEngine{
+		service: service,
+		bk:      amazonSQSBk,
+		opt:     getDefaultOption(),
+	}
+
+	for _, opt := range opts {
+		opt(&worker.opt)
+	}
+	if worker.opt.maxGoroutines <= 0 {
+		// zero size semaphore block receive loop forever
+		worker.opt.maxGoroutines = getDefaultOption().maxGoroutines
+	}
 
-	worker.bk = amazonSQSBk
-	worker.shutdown = make(chan struct{})
+	worker.ctx, worker.ctxCancelFunc = context.WithCancel(context.Background())
+	worker.receiverCtx, worker.receiverCancelFunc = context.WithCancel(context.Background())
+	worker.shutdown = make(chan struct{}, 1)
+	worker.queueUrl = make(map[string]*string)
 	worker.handlers = make(map[string]types.WorkerHandler)
 	worker.semaphore = make(map[string]chan struct{})
 
@@ -55,38 +82,24 @@ func NewAmazonSQSWorker(service factory.ServiceFactory, broker interfaces.Broker
 			var handlerGroup types.WorkerHandlerGroup
 			h.MountHandlers(&handlerGroup)
 			for _, handler := range handlerGroup.Handlers {
-				queueUrlResult, err := worker.bk.Client.GetQueueUrl(worker.ctx, &sqs.GetQueueUrlInput{
-					QueueName: aws.String(handler.Pattern),
-				})
-				if err != nil {
-					log.Panicf("AmazonSQS%s: cannot subscribe to %s: %s", getWorkerTypeLog(worker.bk.WorkerType), handler.Pattern, err.Error())
-				}
-				result, err := worker.bk.Client.ReceiveMessage(worker.ctx, &sqs.ReceiveMessageInput{
-					QueueUrl:            queueUrlResult.QueueUrl,
-					MaxNumberOfMessages: int32(maxNumberMessage),
-					WaitTimeSeconds:     int32(waitTimeSeconds),
-				})
-				if err != nil {
-					log.Panicf("AmazonSQS%s: cannot subscribe to %s: %s", getWorkerTypeLog(worker.bk.WorkerType), handler.Pattern, err.Error())
-				}
-				worker.subscribers[handler.Pattern] = result
-				worker.queueUrl[handler.Pattern] = queueUrlResult
+				worker.queueUrl[handler.Pattern] = worker.createQueue(handler)
 
 				logger.LogYellow(fmt.Sprintf(`[AmazonSQS-CONSUMER]%s (queue): %-15s  --> (module): "%s"`, getWorkerTypeLog(amazonSQSBk.WorkerType), `"`+handler.Pattern+`"`, m.Name()))
 				worker.handlers[handler.Pattern] = handler
-				worker.semaphore[handler.Pattern] = make(chan struct{}, 1)
+				worker.semaphore[handler.Pattern] = make(chan struct{}, worker.opt.maxGoroutines)
 			}
 		}
 	}
 
+	fmt.Printf("\x1b[34;1m⇨ AmazonSQS consumer%s running with %d queues\x1b[0m\n\n", getWorkerTypeLog(amazonSQSBk.WorkerType), len(worker.handlers))
+
 	return worker
 }
 
 func (w *workerEngine) Serve() {
-	for queue, s := range w.subscribers {
-		go func(queue string, s *sqs.ReceiveMessageOutput) {
-			w.processMessage(w.ctx, queue, s)
-		}(queue, s)
+	for queue := range w.queueUrl {
+		w.receiverWg.Add(1)
+		go w.receiveMessage(queue)
 	}
 
 	<-w.shutdown
@@ -99,7 +112,9 @@ func (w *workerEngine) Shutdown(ctx context.Context) {
 	}()
 
 	w.shutdown <- struct{}{}
-	w.isShutdown = true
+	w.receiverCancelFunc()
+	w.receiverWg.Wait()
+
 	runningJob := 0
 	for _, sem := range w.semaphore {
 		runningJob += len(sem)
@@ -109,74 +124,326 @@ func (w *workerEngine) Shutdown(ctx context.Context) {
 	}
 
 	w.wg.Wait()
+	w.ctxCancelFunc()
 }
 
 func (w *workerEngine) Name() string {
 	return string(w.bk.WorkerType)
 }
 
-func (w *workerEngine) processMessage(ctx context.Context, queue string, msg *sqs.ReceiveMessageOutput) {
-	if w.ctx.Err() != nil {
-		logger.LogRed("amazonsqs_consumer > ctx root err: " + w.ctx.Err().Error())
-		return
+// createQueue resolve handler queue url, create the queue if not exist and auto create queue enabled in broker
+func (w *workerEngine) createQueue(handler types.WorkerHandler) *string {
+	queueURL, err := w.bk.resolver.resolve(w.ctx, handler.Pattern)
+	if err == nil {
+		w.bk.registerWorkerQueue(handler.Pattern, queueURL)
+		return aws.String(queueURL)
+	}
+
+	var notExist *sqstypes.QueueDoesNotExist
+	if !w.bk.autoCreateQueue || !errors.As(err, &notExist) {
+		log.Panicf("AmazonSQS%s: cannot subscribe to %s: %s", getWorkerTypeLog(w.bk.WorkerType), handler.Pattern, err.Error())
 	}
 
-	w.semaphore[queue] <- struct{}{}
+	attributes := make(map[string]string, len(w.bk.queueAttributes))
+	for k, v := range w.bk.queueAttributes {
+		attributes[k] = v
+	}
+	handlerAttributes, _ := handler.Configs[ConfigQueueAttributes].(map[string]string)
+	for k, v := range handlerAttributes {
+		attributes[k] = v
+	}
 
-	go func(topic string, msg *sqs.ReceiveMessageOutput) {
-		defer func() { <-w.semaphore[topic] }()
+	queueURL, err = w.bk.resolver.create(w.ctx, handler.Pattern, attributes)
+	if err != nil {
+		log.Panicf("AmazonSQS%s: cannot create queue %s: %s", getWorkerTypeLog(w.bk.WorkerType), handler.Pattern, err.Error())
+	}
+	w.bk.registerWorkerQueue(handler.Pattern, queueURL)
+	return aws.String(queueURL)
+}
 
-		selectedHandler := w.handlers[topic]
-		if selectedHandler.DisableTrace {
-			ctx = tracer.SkipTraceContext(ctx)
-		}
+// receiveMessage long-polling loop for single queue, stop when worker shutdown
+func (w *workerEngine) receiveMessage(queue string) {
+	defer w.receiverWg.Done()
 
-		for _, m := range msg.Messages {
-			trace, ctx := tracer.StartTraceFromHeader(ctx, "AmazonSQSConsumer", m.Attributes)
+	backoff := receiveErrorMinBackoff
+	for {
+		if w.receiverCtx.Err() != nil {
+			return
+		}
 
-			if w.bk.WorkerType != AmazonSQSBroker {
-				trace.SetTag("worker_type", string(w.bk.WorkerType))
+		result, err := w.bk.Client.ReceiveMessage(w.receiverCtx, &sqs.ReceiveMessageInput{
+			QueueUrl:            w.queueUrl[queue],
+			MaxNumberOfMessages: w.opt.maxNumberOfMessages,
+			WaitTimeSeconds:     w.opt.waitTimeSeconds,
+			VisibilityTimeout:   w.opt.visibilityTimeout,
+			MessageSystemAttributeNames: []sqstypes.MessageSystemAttributeName{
+				sqstypes.MessageSystemAttributeNameApproximateReceiveCount,
+				sqstypes.MessageSystemAttributeNameMessageGroupId,
+			},
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			if w.receiverCtx.Err() != nil {
+				return
 			}
 
-			trace.SetTag("topic", topic)
-			trace.Log("attributes", msg.ResultMetadata)
-
-			trace.Log("message_id", m.MessageId)
-			trace.Log("body", m.Body)
-			eventContext := candishared.NewEventContext(bytes.NewBuffer(make([]byte, 0, 256)))
-			eventContext.SetContext(ctx)
-			eventContext.SetWorkerType(string(w.bk.WorkerType))
-			eventContext.SetHandlerRoute(topic)
-			eventContext.SetHeader(m.Attributes)
-			if m.MessageId != nil {
-				eventContext.SetKey(*m.MessageId)
+			logger.LogRed(fmt.Sprintf("amazonsqs_consumer > receive message from queue '%s': %s, retry in %s", queue, err.Error(), backoff))
+			select {
+			case <-w.receiverCtx.Done():
+				return
+			case <-time.After(backoff):
 			}
-			if m.Body != nil {
-				eventContext.Write([]byte(*m.Body))
+			backoff = min(backoff*2, receiveErrorMaxBackoff)
+			continue
+		}
+		backoff = receiveErrorMinBackoff
+
+		for _, messages := range w.groupMessages(queue, result.Messages) {
+			select {
+			case <-w.receiverCtx.Done():
+				// unprocessed message will be visible again after visibility timeout
+				return
+			case w.semaphore[queue] <- struct{}{}:
 			}
 
-			for _, handlerFunc := range selectedHandler.HandlerFuncs {
-				if err := handlerFunc(eventContext); err != nil {
-					eventContext.SetError(err)
+			w.wg.Add(1)
+			go func(messages []sqstypes.Message) {
+				defer func() {
+					w.wg.Done()
+					<-w.semaphore[queue]
+				}()
+				for _, message := range messages {
+					if err := w.processMessage(queue, message); err != nil {
+						// keep ordering in message group, remaining messages will be redelivered after visibility timeout
+						break
+					}
 				}
+			}(messages)
+		}
+	}
+}
+
+// groupMessages split received messages into groups which processed serially, each message has its own group
+// in standard queue, and grouped by message group id (preserving receive order) in FIFO queue
+func (w *workerEngine) groupMessages(queue string, messages []sqstypes.Message) (groups [][]sqstypes.Message) {
+	if !isFIFOQueue(aws.ToString(w.queueUrl[queue])) {
+		for _, message := range messages {
+			groups = append(groups, []sqstypes.Message{message})
+		}
+		return groups
+	}
+
+	groupIndex := make(map[string]int)
+	for _, message := range messages {
+		groupID := message.Attributes[string(sqstypes.MessageSystemAttributeNameMessageGroupId)]
+		idx, ok := groupIndex[groupID]
+		if !ok {
+			idx = len(groups)
+			groupIndex[groupID] = idx
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], message)
+	}
+	return groups
+}
+
+func (w *workerEngine) processMessage(queue string, message sqstypes.Message) (err error) {
+	if w.ctx.Err() != nil {
+		logger.LogRed("amazonsqs_consumer > ctx root err: " + w.ctx.Err().Error())
+		return nil
+	}
+
+	if message.MessageId != nil {
+		// lock for multiple worker (if running on multiple pods/instance)
+		if w.opt.locker.IsLocked(w.getLockKey(*message.MessageId)) {
+			return nil
+		}
+		defer w.opt.locker.Unlock(w.getLockKey(*message.MessageId))
+	}
+
+	ctx := w.ctx
+	selectedHandler := w.handlers[queue]
+	if selectedHandler.DisableTrace {
+		ctx = tracer.SkipTraceContext(ctx)
+	}
+
+	stopHeartbeat := w.startVisibilityHeartbeat(queue, message, selectedHandler)
+
+	body, header := aws.ToString(message.Body), messageHeader(message)
+	if envelope, ok := unwrapSNSEnvelope(body); ok {
+		// message fan-out from SNS topic without raw message delivery
+		body = envelope.Message
+		for k, v := range envelope.MessageAttributes {
+			header[k] = v.Value
+		}
+		header[AmazonSNSTopicArnHeader] = envelope.TopicArn
+	}
+
+	trace, ctx := tracer.StartTraceFromHeader(ctx, "AmazonSQSConsumer", header)
+	defer trace.Finish(
+		tracer.FinishWithRecoverPanic(func(panicMessage any) {
+			err = fmt.Errorf("panic: %v", panicMessage)
+		}),
+		tracer.FinishWithFunc(func() {
+			stopHeartbeat()
+			if selectedHandler.AutoACK {
+				w.acknowledgeMessage(queue, message, selectedHandler, err)
 			}
+		}),
+	)
 
-			trace.Finish(
-				tracer.FinishWithRecoverPanic(func(any) {}),
-				tracer.FinishWithFunc(func() {
-					if selectedHandler.AutoACK {
-						selectedQueueUrl := w.queueUrl[topic]
-						w.bk.Client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
-							QueueUrl:      selectedQueueUrl.QueueUrl,
-							ReceiptHandle: m.ReceiptHandle,
-						})
-					}
-				}),
-			)
+	if w.bk.WorkerType != AmazonSQSBroker {
+		trace.SetTag("worker_type", string(w.bk.WorkerType))
+	}
+	trace.SetTag("queue", queue)
+	trace.Log("header", header)
+	trace.Log("message_id", message.MessageId)
+	trace.Log("body", body)
+
+	if w.opt.debugMode {
+		log.Printf("\x1b[35;3mAmazonSQS Worker%s: consuming message from queue '%s'\x1b[0m", getWorkerTypeLog(w.bk.WorkerType), queue)
+	}
+
+	eventContext := candishared.NewEventContext(bytes.NewBuffer(make([]byte, 0, 256)))
+	eventContext.SetContext(ctx)
+	eventContext.SetWorkerType(string(w.bk.WorkerType))
+	eventContext.SetHandlerRoute(queue)
+	eventContext.SetHeader(header)
+	if message.MessageId != nil {
+		eventContext.SetKey(*message.MessageId)
+	}
+	eventContext.WriteString(body)
+
+	for _, handlerFunc := range selectedHandler.HandlerFuncs {
+		if handlerErr := handlerFunc(eventContext); handlerErr != nil {
+			err = handlerErr
+			eventContext.SetError(err)
+			trace.SetError(err)
 		}
+	}
+	return err
+}
 
-		log.Printf("\x1b[35;3mAmazonSQS Worker%s: consuming message from topic '%s'\x1b[0m", getWorkerTypeLog(w.bk.WorkerType), topic)
-	}(queue, msg)
+// acknowledgeMessage delete message if handler succeed, otherwise leave message for redelivery with backoff
+// or forward to dead-letter queue after max receive count exceeded
+func (w *workerEngine) acknowledgeMessage(queue string, message sqstypes.Message, handler types.WorkerHandler, handlerErr error) {
+	if handlerErr == nil {
+		w.deleteMessage(queue, message)
+		return
+	}
+
+	receiveCount, _ := strconv.Atoi(message.Attributes[string(sqstypes.MessageSystemAttributeNameApproximateReceiveCount)])
+	maxReceiveCount, _ := handler.Configs[ConfigMaxReceiveCount].(int)
+	deadLetterQueue, _ := handler.Configs[ConfigDeadLetterQueue].(string)
+	if maxReceiveCount > 0 && receiveCount >= maxReceiveCount && deadLetterQueue != "" {
+		if err := w.sendToDeadLetterQueue(deadLetterQueue, queue, message, receiveCount, handlerErr); err != nil {
+			logger.LogRed(fmt.Sprintf("amazonsqs_consumer > forward message '%s' to dead-letter queue '%s': %s",
+				aws.ToString(message.MessageId), deadLetterQueue, err.Error()))
+			return
+		}
+		w.deleteMessage(queue, message)
+		return
+	}
+
+	if baseBackoff, _ := handler.Configs[ConfigRetryBackoff].(time.Duration); baseBackoff > 0 {
+		_, err := w.bk.Client.ChangeMessageVisibility(w.ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          w.queueUrl[queue],
+			ReceiptHandle:     message.ReceiptHandle,
+			VisibilityTimeout: int32(retryBackoff(baseBackoff, receiveCount) / time.Second),
+		})
+		if err != nil {
+			logger.LogRed(fmt.Sprintf("amazonsqs_consumer > set retry backoff message '%s' in queue '%s': %s",
+				aws.ToString(message.MessageId), queue, err.Error()))
+		}
+	}
+}
+
+func (w *workerEngine) deleteMessage(queue string, message sqstypes.Message) {
+	_, err := w.bk.Client.DeleteMessage(w.ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      w.queueUrl[queue],
+		ReceiptHandle: message.ReceiptHandle,
+	})
+	if err != nil {
+		logger.LogRed(fmt.Sprintf("amazonsqs_consumer > delete message '%s' in queue '%s': %s",
+			aws.ToString(message.MessageId), queue, err.Error()))
+	}
+}
+
+func (w *workerEngine) sendToDeadLetterQueue(deadLetterQueue, queue string, message sqstypes.Message, receiveCount int, handlerErr error) error {
+	attributes, dropped := deadLetterMessageAttributes(message.MessageAttributes, map[string]sqstypes.MessageAttributeValue{
+		AmazonSQSErrorHeader:       stringAttributeValue(handlerErr.Error()),
+		AmazonSQSSourceQueueHeader: stringAttributeValue(queue),
+		AmazonSQSReceiveCountHeader: {
+			DataType: aws.String("Number"), StringValue: aws.String(strconv.Itoa(receiveCount)),
+		},
+	})
+	if len(dropped) > 0 {
+		logger.LogYellow(fmt.Sprintf("%s > message %s exceed max attributes in dead-letter queue, dropped attributes: %v",
+			w.Name(), aws.ToString(message.MessageId), dropped))
+	}
+
+	deadLetterQueueURL, err := w.bk.resolver.resolve(w.ctx, deadLetterQueue)
+	if err != nil {
+		return err
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(deadLetterQueueURL),
+		MessageBody:       message.Body,
+		MessageAttributes: attributes,
+	}
+	if isFIFOQueue(deadLetterQueue) {
+		groupID, ok := message.Attributes[string(sqstypes.MessageSystemAttributeNameMessageGroupId)]
+		if !ok {
+			groupID = queue
+		}
+		input.MessageGroupId = aws.String(groupID)
+		input.MessageDeduplicationId = message.MessageId
+	}
+	_, err = w.bk.Client.SendMessage(w.ctx, input)
+	return err
+}
+
+// startVisibilityHeartbeat periodically extend visibility timeout of in-flight message while handler still running,
+// stop extending when returned func called or worker stopped
+func (w *workerEngine) startVisibilityHeartbeat(queue string, message sqstypes.Message, handler types.WorkerHandler) (stop func()) {
+	interval, _ := handler.Configs[ConfigVisibilityHeartbeat].(time.Duration)
+	if interval <= 0 || message.ReceiptHandle == nil {
+		return func() {}
+	}
+	extension, ok := handler.Configs[ConfigVisibilityExtension].(time.Duration)
+	if !ok || extension <= interval {
+		extension = 2 * interval
+	}
+	extension = min(extension, maxVisibilityTimeout)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-w.ctx.Done():
+				// ctx is cancelled after running handlers done, so heartbeat keep extending while shutdown draining
+				return
+			case <-ticker.C:
+				_, err := w.bk.Client.ChangeMessageVisibility(w.ctx, &sqs.ChangeMessageVisibilityInput{
+					QueueUrl:          w.queueUrl[queue],
+					ReceiptHandle:     message.ReceiptHandle,
+					VisibilityTimeout: int32(extension / time.Second),
+				})
+				if err != nil {
+					logger.LogRed(fmt.Sprintf("amazonsqs_consumer > extend visibility timeout message '%s' in queue '%s': %s",
+						aws.ToString(message.MessageId), queue, err.Error()))
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
 }
 
 func (w *workerEngine) getLockKey(eventID string) string {
@@ -189,3 +456,12 @@ func getWorkerTypeLog(name types.Worker) (workerType string) {
 	}
 	return
 }
+
+// retryBackoff exponential backoff from receive count, capped by max visibility timeout
+func retryBackoff(base time.Duration, receiveCount int) time.Duration {
+	backoff := base
+	for i := 1; i < receiveCount && backoff < maxVisibilityTimeout; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxVisibilityTimeout)
+}
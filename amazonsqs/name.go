@@ -5,4 +5,26 @@ import "github.com/golangid/candi/codebase/factory/types"
 const (
 	// AmazonSQSBroker types
 	AmazonSQSBroker types.Worker = "amazonsqs_broker"
+
+	// ConfigVisibilityHeartbeat handler config key (time.Duration), interval for extending visibility timeout of in-flight message
+	ConfigVisibilityHeartbeat string = "visibilityHeartbeat"
+	// ConfigVisibilityExtension handler config key (time.Duration), visibility timeout set on each heartbeat, default is twice the heartbeat interval
+	ConfigVisibilityExtension string = "visibilityExtension"
+	// ConfigRetryBackoff handler config key (time.Duration), base delay of exponential backoff before failed message visible again
+	ConfigRetryBackoff string = "retryBackoff"
+	// ConfigMaxReceiveCount handler config key (int), max attempts before failed message forwarded to dead-letter queue
+	ConfigMaxReceiveCount string = "maxReceiveCount"
+	// ConfigDeadLetterQueue handler config key (string), dead-letter queue name or url for message which exceed max receive count
+	ConfigDeadLetterQueue string = "deadLetterQueue"
+	// ConfigQueueAttributes handler config key (map[string]string), attributes for creating missing queue when auto create queue enabled
+	ConfigQueueAttributes string = "queueAttributes"
+
+	// AmazonSQSErrorHeader message attribute key for handler error in dead-letter queue
+	AmazonSQSErrorHeader = "amazonSQSError"
+	// AmazonSQSSourceQueueHeader message attribute key for source queue in dead-letter queue
+	AmazonSQSSourceQueueHeader = "amazonSQSSourceQueue"
+	// AmazonSQSReceiveCountHeader message attribute key for receive count in dead-letter queue
+	AmazonSQSReceiveCountHeader = "amazonSQSReceiveCount"
+	// AmazonSNSTopicArnHeader header key for source SNS topic ARN when consuming SNS notification from queue
+	AmazonSNSTopicArnHeader = "amazonSNSTopicArn"
 )
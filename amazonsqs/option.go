@@ -7,8 +7,12 @@ import (
 
 type (
 	option struct {
-		locker    interfaces.Locker
-		debugMode bool
+		locker              interfaces.Locker
+		debugMode           bool
+		maxGoroutines       int
+		maxNumberOfMessages int32
+		waitTimeSeconds     int32
+		visibilityTimeout   int32
 	}
 
 	// OptionFunc type
@@ -17,8 +21,11 @@ type (
 
 func getDefaultOption() option {
 	return option{
-		debugMode: true,
-		locker:    &candiutils.NoopLocker{},
+		debugMode:           true,
+		locker:              &candiutils.NoopLocker{},
+		maxGoroutines:       10,
+		maxNumberOfMessages: 10,
+		waitTimeSeconds:     20,
 	}
 }
 
@@ -29,9 +36,37 @@ func SetDebugMode(debugMode bool) OptionFunc {
 	}
 }
 
-// SetLocker option func
+// SetLocker option func, lock message id for multiple worker (if running on multiple pods/instance)
 func SetLocker(locker interfaces.Locker) OptionFunc {
 	return func(o *option) {
 		o.locker = locker
 	}
 }
+
+// SetMaxGoroutines option func, max concurrent handler for each queue (default 10), default is used if not positive
+func SetMaxGoroutines(maxGoroutines int) OptionFunc {
+	return func(o *option) {
+		o.maxGoroutines = maxGoroutines
+	}
+}
+
+// SetMaxNumberOfMessages option func, max messages returned in single receive (1-10)
+func SetMaxNumberOfMessages(maxNumberOfMessages int32) OptionFunc {
+	return func(o *option) {
+		o.maxNumberOfMessages = maxNumberOfMessages
+	}
+}
+
+// SetWaitTimeSeconds option func, long-polling duration in seconds for single receive (0-20)
+func SetWaitTimeSeconds(waitTimeSeconds int32) OptionFunc {
+	return func(o *option) {
+		o.waitTimeSeconds = waitTimeSeconds
+	}
+}
+
+// SetVisibilityTimeout option func, override queue visibility timeout (in seconds) for received messages
+func SetVisibilityTimeout(visibilityTimeout int32) OptionFunc {
+	return func(o *option) {
+		o.visibilityTimeout = visibilityTimeout
+	}
+}
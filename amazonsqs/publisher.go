@@ -2,8 +2,10 @@ package amazonsqs
 
 import (
 	"context"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/golangid/candi/candihelper"
 	"github.com/golangid/candi/candishared"
 	"github.com/golangid/candi/codebase/interfaces"
@@ -16,25 +18,55 @@ const (
 	AmazonSQSContentTypeKey = candishared.ContextKey("amazonSQSContentType")
 	// AmazonSQS for event id
 	AmazonSQSEventID = "amazonSQSEventID"
+
+	// defaultMessageGroupID message group id for FIFO queue when publisher argument key is empty
+	defaultMessageGroupID = "default"
 )
 
 // publisher instance
 type publisher struct {
-	client *sqs.Client
+	client   *sqs.Client
+	resolver *queueResolver
 }
 
-// NewPublisher constructor
+// NewPublisher constructor, topic in publisher argument can be queue name or url
 func NewPublisher(client *sqs.Client) interfaces.Publisher {
 	return &publisher{
-		client: client,
+		client:   client,
+		resolver: newQueueResolver(client),
 	}
 }
 
+func (p *publisher) setQueueResolver(resolver *queueResolver) {
+	p.resolver = resolver
+}
+
 // PublishMessage method
 func (p *publisher) PublishMessage(ctx context.Context, args *candishared.PublisherArgument) (err error) {
 	trace, ctx := tracer.StartTraceWithContext(ctx, "AmazonSQSPublisher:PublishMessage")
-	defer trace.Finish()
+	defer func() { trace.Finish(tracer.FinishWithError(err)) }()
 
+	queueURL, err := p.resolver.resolve(ctx, args.Topic)
+	if err != nil {
+		return err
+	}
+
+	input, err := newSendMessageInput(ctx, trace, args)
+	if err != nil {
+		return err
+	}
+	input.QueueUrl = aws.String(queueURL)
+	result, err := p.client.SendMessage(ctx, input)
+	if err == nil {
+		trace.SetTag("message_id", result.MessageId)
+		trace.SetTag("sequence_number", result.SequenceNumber)
+	}
+	return err
+}
+
+// newSendMessageInput build sqs message from publisher argument, shared by single and batch publisher,
+// return error if header is not valid message attribute or exceed max attributes
+func newSendMessageInput(ctx context.Context, trace tracer.Tracer, args *candishared.PublisherArgument) (*sqs.SendMessageInput, error) {
 	contentType, ok := candishared.GetValueFromContext(ctx, AmazonSQSContentTypeKey).(string)
 	if !ok {
 		contentType = "text/plain"
@@ -56,14 +88,35 @@ func (p *publisher) PublishMessage(ctx context.Context, args *candishared.Publis
 		AmazonSQSEventID: uuid.NewString(),
 	}
 	trace.InjectRequestHeader(header)
+	for k, v := range args.Header {
+		if err := validateAttributeName(k); err != nil {
+			return nil, err
+		}
+		header[k] = string(candihelper.ToBytes(v))
+	}
 
-	result, err := p.client.SendMessage(ctx, &sqs.SendMessageInput{
-		MessageBody: aws.String(string(message)),
-		QueueUrl:    &args.Topic,
-	})
-	if err == nil {
-		trace.SetTag("message_id", result.MessageId)
-		trace.SetTag("sequence_number", result.SequenceNumber)
+	input := &sqs.SendMessageInput{
+		MessageBody:       aws.String(string(message)),
+		QueueUrl:          aws.String(args.Topic),
+		MessageAttributes: make(map[string]sqstypes.MessageAttributeValue, len(header)),
 	}
-	return err
+	for k, v := range header {
+		if v != "" {
+			input.MessageAttributes[k] = stringAttributeValue(v)
+		}
+	}
+	if len(input.MessageAttributes) > maxMessageAttributes {
+		return nil, fmt.Errorf("message has %d attributes (including event id and trace context), max %d attributes",
+			len(input.MessageAttributes), maxMessageAttributes)
+	}
+	if isFIFOQueue(args.Topic) {
+		// message in same group is delivered in order, event id prevent duplicate within deduplication interval
+		groupID := args.Key
+		if groupID == "" {
+			groupID = defaultMessageGroupID
+		}
+		input.MessageGroupId = aws.String(groupID)
+		input.MessageDeduplicationId = aws.String(header[AmazonSQSEventID])
+	}
+	return input, nil
 }
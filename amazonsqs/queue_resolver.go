@@ -0,0 +1,92 @@
+package amazonsqs
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// queueResolver resolve queue name or url into queue url and cache the result
+type queueResolver struct {
+	client *sqs.Client
+
+	mu   sync.RWMutex
+	urls map[string]string
+}
+
+func newQueueResolver(client *sqs.Client) *queueResolver {
+	return &queueResolver{
+		client: client,
+		urls:   make(map[string]string),
+	}
+}
+
+// resolve queue url from queue name or url
+func (r *queueResolver) resolve(ctx context.Context, queue string) (string, error) {
+	if isQueueURL(queue) {
+		r.store(queue, queue)
+		return queue, nil
+	}
+
+	r.mu.RLock()
+	queueURL, ok := r.urls[queue]
+	r.mu.RUnlock()
+	if ok {
+		return queueURL, nil
+	}
+
+	result, err := r.client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(queue),
+	})
+	if err != nil {
+		return "", err
+	}
+	queueURL = aws.ToString(result.QueueUrl)
+	r.store(queue, queueURL)
+	return queueURL, nil
+}
+
+// create queue with attributes, FIFO attribute is set when queue name has ".fifo" suffix
+func (r *queueResolver) create(ctx context.Context, queue string, attributes map[string]string) (string, error) {
+	queueAttributes := make(map[string]string, len(attributes)+1)
+	for k, v := range attributes {
+		queueAttributes[k] = v
+	}
+	name := queueName(queue)
+	if isFIFOQueue(name) {
+		queueAttributes[string(sqstypes.QueueAttributeNameFifoQueue)] = "true"
+	}
+
+	result, err := r.client.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName:  aws.String(name),
+		Attributes: queueAttributes,
+	})
+	if err != nil {
+		return "", err
+	}
+	queueURL := aws.ToString(result.QueueUrl)
+	r.store(queue, queueURL)
+	return queueURL, nil
+}
+
+func (r *queueResolver) store(queue, queueURL string) {
+	r.mu.Lock()
+	r.urls[queue] = queueURL
+	r.mu.Unlock()
+}
+
+func isQueueURL(queue string) bool {
+	return strings.HasPrefix(queue, "https://") || strings.HasPrefix(queue, "http://")
+}
+
+// queueName get queue name from queue name or url
+func queueName(queue string) string {
+	if !isQueueURL(queue) {
+		return queue
+	}
+	return queue[strings.LastIndex(queue, "/")+1:]
+}
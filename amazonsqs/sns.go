@@ -0,0 +1,91 @@
+package amazonsqs
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/golangid/candi/candishared"
+	"github.com/golangid/candi/codebase/interfaces"
+	"github.com/golangid/candi/tracer"
+)
+
+// InitDefaultSNSConnection amazon sns
+func InitDefaultSNSConnection(options ...func(*config.LoadOptions) error) *sns.Client {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), options...)
+	if err != nil {
+		panic(err)
+	}
+
+	return sns.NewFromConfig(cfg)
+}
+
+// snsPublisher instance
+type snsPublisher struct {
+	client *sns.Client
+}
+
+// NewSNSPublisher constructor, topic in publisher argument is SNS topic ARN
+func NewSNSPublisher(client *sns.Client) interfaces.Publisher {
+	return &snsPublisher{
+		client: client,
+	}
+}
+
+// PublishMessage method
+func (p *snsPublisher) PublishMessage(ctx context.Context, args *candishared.PublisherArgument) (err error) {
+	trace, ctx := tracer.StartTraceWithContext(ctx, "AmazonSNSPublisher:PublishMessage")
+	defer func() { trace.Finish(tracer.FinishWithError(err)) }()
+
+	// same message format with sqs publisher, so it can be consumed from subscribed queue with raw message delivery
+	message, err := newSendMessageInput(ctx, trace, args)
+	if err != nil {
+		return err
+	}
+	input := &sns.PublishInput{
+		TopicArn:               aws.String(args.Topic),
+		Message:                message.MessageBody,
+		MessageGroupId:         message.MessageGroupId,
+		MessageDeduplicationId: message.MessageDeduplicationId,
+		MessageAttributes:      make(map[string]snstypes.MessageAttributeValue, len(message.MessageAttributes)),
+	}
+	for k, v := range message.MessageAttributes {
+		input.MessageAttributes[k] = snstypes.MessageAttributeValue{
+			DataType: v.DataType, StringValue: v.StringValue, BinaryValue: v.BinaryValue,
+		}
+	}
+
+	result, err := p.client.Publish(ctx, input)
+	if err == nil {
+		trace.SetTag("message_id", result.MessageId)
+		trace.SetTag("sequence_number", result.SequenceNumber)
+	}
+	return err
+}
+
+// snsEnvelope notification envelope delivered to subscribed queue when raw message delivery disabled
+type snsEnvelope struct {
+	Type              string `json:"Type"`
+	MessageID         string `json:"MessageId"`
+	TopicArn          string `json:"TopicArn"`
+	Message           string `json:"Message"`
+	MessageAttributes map[string]struct {
+		Type  string `json:"Type"`
+		Value string `json:"Value"`
+	} `json:"MessageAttributes"`
+}
+
+// unwrapSNSEnvelope return original message and attributes if body is SNS notification envelope
+func unwrapSNSEnvelope(body string) (envelope snsEnvelope, ok bool) {
+	if !strings.HasPrefix(strings.TrimSpace(body), "{") || !strings.Contains(body, `"TopicArn"`) {
+		return envelope, false
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return envelope, false
+	}
+	return envelope, envelope.Type == "Notification" && envelope.TopicArn != ""
+}
@@ -0,0 +1,87 @@
+package amazonsqs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// isFIFOQueue check FIFO queue from queue name or url
+func isFIFOQueue(queue string) bool {
+	return strings.HasSuffix(queue, ".fifo")
+}
+
+const (
+	// maxMessageAttributes max message attributes of single sqs message
+	maxMessageAttributes = 10
+	// maxAttributeNameLength max length of message attribute name
+	maxAttributeNameLength = 256
+)
+
+// validateAttributeName check message attribute naming rules of sqs
+func validateAttributeName(name string) error {
+	lower := strings.ToLower(name)
+	switch {
+	case name == "" || len(name) > maxAttributeNameLength:
+		return fmt.Errorf("invalid message attribute name %q: length must be 1-%d", name, maxAttributeNameLength)
+	case strings.HasPrefix(lower, "aws.") || strings.HasPrefix(lower, "amazon."):
+		return fmt.Errorf("invalid message attribute name %q: prefix AWS. and Amazon. are reserved", name)
+	case strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, ".."):
+		return fmt.Errorf("invalid message attribute name %q: must not start/end with period or contain successive periods", name)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return fmt.Errorf("invalid message attribute name %q: only alphanumeric, hyphen, underscore and period are allowed", name)
+		}
+	}
+	return nil
+}
+
+// deadLetterMessageAttributes original attributes with dead-letter attributes, original attributes are dropped
+// (sorted by name) when exceed max message attributes
+func deadLetterMessageAttributes(original, deadLetter map[string]sqstypes.MessageAttributeValue) (attributes map[string]sqstypes.MessageAttributeValue, dropped []string) {
+	attributes = make(map[string]sqstypes.MessageAttributeValue, maxMessageAttributes)
+	for k, v := range deadLetter {
+		attributes[k] = v
+	}
+	names := make([]string, 0, len(original))
+	for k := range original {
+		if _, ok := attributes[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if len(attributes) >= maxMessageAttributes {
+			dropped = append(dropped, k)
+			continue
+		}
+		attributes[k] = original[k]
+	}
+	return attributes, dropped
+}
+
+func stringAttributeValue(value string) sqstypes.MessageAttributeValue {
+	return sqstypes.MessageAttributeValue{
+		DataType: aws.String("String"), StringValue: aws.String(value),
+	}
+}
+
+// messageHeader merge system attributes and message attributes (sent by publisher, including trace context) into single header
+func messageHeader(message sqstypes.Message) map[string]string {
+	header := make(map[string]string, len(message.Attributes)+len(message.MessageAttributes))
+	for k, v := range message.Attributes {
+		header[k] = v
+	}
+	for k, v := range message.MessageAttributes {
+		if v.StringValue != nil {
+			header[k] = *v.StringValue
+		} else {
+			header[k] = string(v.BinaryValue)
+		}
+	}
+	return header
+}
@@ -7,7 +7,7 @@ import (
 	"github.com/golangid/candi/codebase/factory/types"
 	"github.com/golangid/candi/codebase/interfaces"
 	"github.com/golangid/candi/logger"
-	"google.golang.org/api/option"
+	apioption "google.golang.org/api/option"
 )
 
 // BrokerOptionFunc func type
@@ -36,7 +36,7 @@ func BrokerSetPublisher(pub interfaces.Publisher) BrokerOptionFunc {
 
 // InitDefaultClient setup gcp pubsub client
 func InitDefaultClient(gcpProjectName, credentialPath string) *pubsub.Client {
-	client, err := pubsub.NewClient(context.Background(), gcpProjectName, option.WithCredentialsFile(credentialPath))
+	client, err := pubsub.NewClient(context.Background(), gcpProjectName, apioption.WithCredentialsFile(credentialPath))
 	if err != nil {
 		panic(err)
 	}
@@ -95,5 +95,11 @@ func (g *Broker) Disconnect(ctx context.Context) error {
 	deferFunc := logger.LogWithDefer("gcp pubsub: disconnect...")
 	defer deferFunc()
 
+	// flush pending messages and stop topic handles before closing client
+	if closer, ok := g.publisher.(interface{ Close(context.Context) error }); ok {
+		if err := closer.Close(ctx); err != nil {
+			logger.LogRed("gcp pubsub: stop publisher: " + err.Error())
+		}
+	}
 	return g.Client.Close()
 }
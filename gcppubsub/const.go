@@ -9,6 +9,34 @@ const (
 	// GoogleCloudPubSub types
 	GoogleCloudPubSub types.Worker = "gcppubsub"
 
+	// ConfigMaxGoroutines handler config key (int), max concurrent processed messages for handler topic,
+	// worker SetMaxGoroutines option is used if not positive
+	ConfigMaxGoroutines string = "maxGoroutines"
+	// ConfigEnableMessageOrdering handler config key (bool), create subscription with message ordering,
+	// messages with same ordering key are processed sequentially
+	ConfigEnableMessageOrdering string = "enableMessageOrdering"
+
+	// ConfigFilter handler config key (string), subscription filter expression (e.g. `attributes.type = "order"`),
+	// only applied when subscription is created
+	ConfigFilter string = "filter"
+	// ConfigAckDeadline handler config key (time.Duration), subscription ack deadline, default 20 seconds
+	ConfigAckDeadline string = "ackDeadline"
+	// ConfigRetentionDuration handler config key (time.Duration), retention of unacknowledged messages
+	ConfigRetentionDuration string = "retentionDuration"
+	// ConfigExpirationPolicy handler config key (time.Duration), subscription expired after inactive duration, zero is never expire
+	ConfigExpirationPolicy string = "expirationPolicy"
+	// ConfigEnableExactlyOnceDelivery handler config key (bool), subscription exactly-once delivery
+	ConfigEnableExactlyOnceDelivery string = "enableExactlyOnceDelivery"
+	// ConfigPushEndpoint handler config key (string), provision push subscription to endpoint, not received by worker
+	ConfigPushEndpoint string = "pushEndpoint"
+	// ConfigPushServiceAccount handler config key (string), service account email for OIDC token of push request
+	ConfigPushServiceAccount string = "pushServiceAccount"
+
 	// MessageAttribute key types
 	MessageAttribute candishared.ContextKey = "message_attributes"
+	// DeliveryAttempt key types, delivery attempt (int) of consumed message, zero if subscription has no dead-letter policy
+	DeliveryAttempt candishared.ContextKey = "delivery_attempt"
+
+	// DeliveryAttemptHeader header key for delivery attempt in event context header
+	DeliveryAttemptHeader = "gcppubsubDeliveryAttempt"
 )
@@ -5,7 +5,8 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"time"
+	"strconv"
+	"sync"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/golangid/candi/candishared"
@@ -22,14 +23,16 @@ type workerEngine struct {
 
 	semaphore map[string]chan struct{}
 	shutdown  chan struct{}
+	wg        sync.WaitGroup
 	bk        *Broker
+	opt       option
 
 	subscribers map[string]*pubsub.Subscription
 	handlers    map[string]types.WorkerHandler
 }
 
 // NewPubSubWorker create new gcp pubsub consumer, throw panic if error happened
-func NewPubSubWorker(service factory.ServiceFactory, broker interfaces.Broker, subscriberID string) factory.AppServerFactory {
+func NewPubSubWorker(service factory.ServiceFactory, broker interfaces.Broker, subscriberID string, opts ...OptionFunc) factory.AppServerFactory {
 	gcpBk, ok := broker.(*Broker)
 	if !ok {
 		panic("Missing GCP PubSub broker, make sure GCP PubSub has been registered to broker in service config")
@@ -39,6 +42,17 @@ func NewPubSubWorker(service factory.ServiceFactory, broker interfaces.Broker, s
 	worker.ctx, worker.ctxCancelFunc = context.WithCancel(context.Background())
 
 	worker.bk = gcpBk
+	worker.opt = getDefaultOption()
+	for _, opt := range opts {
+		opt(&worker.opt)
+	}
+	if worker.opt.maxGoroutines <= 0 {
+		// zero size semaphore block message callback forever
+		worker.opt.maxGoroutines = getDefaultOption().maxGoroutines
+	}
+	if worker.opt.deadLetterPolicy != nil {
+		worker.opt.deadLetterPolicy.DeadLetterTopic = worker.createTopic(worker.opt.deadLetterTopic).String()
+	}
 	worker.shutdown = make(chan struct{})
 	worker.subscribers = make(map[string]*pubsub.Subscription)
 	worker.handlers = make(map[string]types.WorkerHandler)
@@ -49,12 +63,27 @@ func NewPubSubWorker(service factory.ServiceFactory, broker interfaces.Broker, s
 			var handlerGroup types.WorkerHandlerGroup
 			h.MountHandlers(&handlerGroup)
 			for _, handler := range handlerGroup.Handlers {
+				maxGoroutines, ok := handler.Configs[ConfigMaxGoroutines].(int)
+				if !ok || maxGoroutines <= 0 {
+					maxGoroutines = worker.opt.maxGoroutines
+				}
+
 				topic := worker.createTopic(handler.Pattern)
-				worker.subscribers[handler.Pattern] = worker.createSubscription(subscriberID+"_"+handler.Pattern, topic)
+				sub := worker.createSubscription(subscriberID+"_"+handler.Pattern, topic, handler)
+				sub.ReceiveSettings = worker.opt.receiveSettings
+				if sub.ReceiveSettings.MaxOutstandingMessages == 0 {
+					sub.ReceiveSettings.MaxOutstandingMessages = maxGoroutines
+				}
+				if endpoint, _ := handler.Configs[ConfigPushEndpoint].(string); endpoint != "" {
+					// push subscription is delivered to endpoint, not received by this worker
+					logger.LogYellow(fmt.Sprintf(`[GCPPUBSUB-CONSUMER]%s (topic): %-15s  --> (push endpoint): "%s"`, getWorkerTypeLog(gcpBk.WorkerType), `"`+handler.Pattern+`"`, endpoint))
+					continue
+				}
+				worker.subscribers[handler.Pattern] = sub
 
 				logger.LogYellow(fmt.Sprintf(`[GCPPUBSUB-CONSUMER]%s (topic): %-15s  --> (module): "%s"`, getWorkerTypeLog(gcpBk.WorkerType), `"`+handler.Pattern+`"`, m.Name()))
 				worker.handlers[handler.Pattern] = handler
-				worker.semaphore[handler.Pattern] = make(chan struct{}, 1)
+				worker.semaphore[handler.Pattern] = make(chan struct{}, maxGoroutines)
 			}
 		}
 	}
@@ -67,8 +96,13 @@ func NewPubSubWorker(service factory.ServiceFactory, broker interfaces.Broker, s
 
 func (w *workerEngine) Serve() {
 	for topic, subs := range w.subscribers {
+		w.wg.Add(1)
 		go func(sub *pubsub.Subscription, topic string) {
-			sub.Receive(w.ctx, func(ctx context.Context, msg *pubsub.Message) { w.processMessage(ctx, topic, msg) })
+			defer w.wg.Done()
+			err := sub.Receive(w.ctx, func(ctx context.Context, msg *pubsub.Message) { w.processMessage(ctx, topic, msg) })
+			if err != nil {
+				logger.LogRed(fmt.Sprintf("gcppubsub_consumer > receive topic '%s': %s", topic, err.Error()))
+			}
 		}(subs, topic)
 	}
 
@@ -83,8 +117,18 @@ func (w *workerEngine) Shutdown(ctx context.Context) {
 	}()
 
 	w.ctxCancelFunc()
+	// receive return after all running handlers done
+	receiveDone := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(receiveDone)
+	}()
+	select {
+	case <-receiveDone:
+	case <-ctx.Done():
+	}
 	w.shutdown <- struct{}{}
-	w.bk.Client.Close()
+	// client is closed on broker disconnect, after publisher flushed pending messages
 }
 
 func (w *workerEngine) Name() string {
@@ -106,21 +150,22 @@ func (w *workerEngine) createTopic(topicName string) *pubsub.Topic {
 	return topic
 }
 
-func (w *workerEngine) createSubscription(subscriberID string, topic *pubsub.Topic) *pubsub.Subscription {
+func (w *workerEngine) createSubscription(subscriberID string, topic *pubsub.Topic, handler types.WorkerHandler) *pubsub.Subscription {
 	sub := w.bk.Client.Subscription(subscriberID)
 	ok, err := sub.Exists(w.ctx)
 	if err != nil {
 		panic("GCP PubSub check subscriber " + subscriberID + ": " + err.Error())
 	}
-	// sub.Update(w.ctx, pubsub.SubscriptionConfigToUpdate{})
-	if !ok {
-		sub, err = w.bk.Client.CreateSubscription(w.ctx, subscriberID, pubsub.SubscriptionConfig{
-			Topic:       topic,
-			AckDeadline: 20 * time.Second,
-		})
-		if err != nil {
-			panic("GCP PubSub create subscriber " + subscriberID + ": " + err.Error())
-		}
+
+	desired := w.subscriptionConfig(topic, handler)
+	if ok {
+		w.reconcileSubscription(sub, desired, handler)
+		return sub
+	}
+
+	sub, err = w.bk.Client.CreateSubscription(w.ctx, subscriberID, desired)
+	if err != nil {
+		panic("GCP PubSub create subscriber " + subscriberID + ": " + err.Error())
 	}
 	return sub
 }
@@ -131,49 +176,87 @@ func (w *workerEngine) processMessage(ctx context.Context, topic string, msg *pu
 		return
 	}
 
-	w.semaphore[topic] <- struct{}{}
-
-	go func(topic string, msg *pubsub.Message) {
-		defer func() { <-w.semaphore[topic] }()
+	// process message in receive callback, client run callbacks concurrently (limited by max outstanding messages)
+	// and only deliver next message with same ordering key after callback returned
+	select {
+	case w.semaphore[topic] <- struct{}{}:
+	case <-w.ctx.Done():
+		return
+	}
+	defer func() { <-w.semaphore[topic] }()
 
-		selectedHandler := w.handlers[topic]
-		if selectedHandler.DisableTrace {
-			ctx = tracer.SkipTraceContext(ctx)
-		}
+	w.handleMessage(ctx, topic, msg)
+}
 
-		trace, ctx := tracer.StartTraceFromHeader(ctx, "GCPPubSubConsumer", msg.Attributes)
-		defer trace.Finish(
-			tracer.FinishWithRecoverPanic(func(any) {}),
-			tracer.FinishWithFunc(func() {
-				if selectedHandler.AutoACK {
-					msg.Ack()
-				}
-			}),
-		)
+// handleMessage run handler funcs, ack message if succeed and nack if error or panic
+func (w *workerEngine) handleMessage(ctx context.Context, topic string, msg *pubsub.Message) {
+	selectedHandler := w.handlers[topic]
+	if selectedHandler.DisableTrace {
+		ctx = tracer.SkipTraceContext(ctx)
+	}
 
-		if w.bk.WorkerType != GoogleCloudPubSub {
-			trace.SetTag("worker_type", string(w.bk.WorkerType))
+	var err error
+	trace, ctx := tracer.StartTraceFromHeader(ctx, "GCPPubSubConsumer", msg.Attributes)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			trace.SetError(err)
 		}
-		trace.SetTag("topic", topic)
-		trace.Log("attributes", msg.Attributes)
-		trace.Log("body", msg.Data)
-
-		log.Printf("\x1b[35;3mGCP PubSub Worker%s: consuming message from topic '%s'\x1b[0m", getWorkerTypeLog(w.bk.WorkerType), topic)
-
-		eventContext := candishared.NewEventContext(bytes.NewBuffer(make([]byte, 0, 256)))
-		eventContext.SetContext(ctx)
-		eventContext.SetWorkerType(string(w.bk.WorkerType))
-		eventContext.SetHandlerRoute(topic)
-		eventContext.SetHeader(msg.Attributes)
-		eventContext.SetKey(msg.ID)
-		eventContext.Write(msg.Data)
-
-		for _, handlerFunc := range selectedHandler.HandlerFuncs {
-			if err := handlerFunc(eventContext); err != nil {
-				eventContext.SetError(err)
+
+		if selectedHandler.AutoACK {
+			// nacked message is redelivered with subscription retry policy,
+			// and forwarded to dead-letter topic after max delivery attempts
+			if err != nil {
+				msg.Nack()
+			} else {
+				msg.Ack()
 			}
 		}
-	}(topic, msg)
+		trace.Finish()
+	}()
+
+	if w.bk.WorkerType != GoogleCloudPubSub {
+		trace.SetTag("worker_type", string(w.bk.WorkerType))
+	}
+	trace.SetTag("topic", topic)
+	if msg.OrderingKey != "" {
+		trace.SetTag("ordering_key", msg.OrderingKey)
+	}
+	trace.Log("attributes", msg.Attributes)
+	deliveryAttempt := 0
+	if msg.DeliveryAttempt != nil {
+		// only set by server when subscription has dead-letter policy
+		deliveryAttempt = *msg.DeliveryAttempt
+		trace.SetTag("delivery_attempt", deliveryAttempt)
+	}
+	trace.Log("body", msg.Data)
+
+	log.Printf("\x1b[35;3mGCP PubSub Worker%s: consuming message from topic '%s'\x1b[0m", getWorkerTypeLog(w.bk.WorkerType), topic)
+
+	eventContext := candishared.NewEventContext(bytes.NewBuffer(make([]byte, 0, 256)))
+	ctx = context.WithValue(ctx, MessageAttribute, msg.Attributes)
+	ctx = context.WithValue(ctx, DeliveryAttempt, deliveryAttempt)
+	eventContext.SetContext(ctx)
+	eventContext.SetWorkerType(string(w.bk.WorkerType))
+	eventContext.SetHandlerRoute(topic)
+	header := make(map[string]string, len(msg.Attributes)+1)
+	for k, v := range msg.Attributes {
+		header[k] = v
+	}
+	if deliveryAttempt > 0 {
+		header[DeliveryAttemptHeader] = strconv.Itoa(deliveryAttempt)
+	}
+	eventContext.SetHeader(header)
+	eventContext.SetKey(msg.ID)
+	eventContext.Write(msg.Data)
+
+	for _, handlerFunc := range selectedHandler.HandlerFuncs {
+		if handlerErr := handlerFunc(eventContext); handlerErr != nil {
+			err = handlerErr
+			eventContext.SetError(err)
+			trace.SetError(err)
+		}
+	}
 }
 
 func getWorkerTypeLog(name types.Worker) (workerType string) {
@@ -0,0 +1,95 @@
+package gcppubsub
+
+import (
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+type (
+	option struct {
+		maxGoroutines    int
+		receiveSettings  pubsub.ReceiveSettings
+		retryPolicy      *pubsub.RetryPolicy
+		deadLetterTopic  string
+		deadLetterPolicy *pubsub.DeadLetterPolicy
+
+		subscriptionDryRun bool
+	}
+
+	// OptionFunc type
+	OptionFunc func(*option)
+)
+
+func getDefaultOption() option {
+	// zero value receive settings is default settings of pubsub client
+	return option{
+		maxGoroutines: 1,
+	}
+}
+
+// SetMaxGoroutines option func, max concurrent processed messages for each topic (default 1), default is used if not positive,
+// can be overridden per handler with ConfigMaxGoroutines
+func SetMaxGoroutines(maxGoroutines int) OptionFunc {
+	return func(o *option) {
+		o.maxGoroutines = maxGoroutines
+	}
+}
+
+// SetMaxOutstandingMessages option func, max unacknowledged messages received by each subscription,
+// default is max goroutines of handler so messages are not leased while waiting to be processed
+func SetMaxOutstandingMessages(maxOutstandingMessages int) OptionFunc {
+	return func(o *option) {
+		o.receiveSettings.MaxOutstandingMessages = maxOutstandingMessages
+	}
+}
+
+// SetMaxOutstandingBytes option func, max size of unacknowledged messages received by each subscription
+func SetMaxOutstandingBytes(maxOutstandingBytes int) OptionFunc {
+	return func(o *option) {
+		o.receiveSettings.MaxOutstandingBytes = maxOutstandingBytes
+	}
+}
+
+// SetNumGoroutines option func, number of streaming pull for each subscription
+func SetNumGoroutines(numGoroutines int) OptionFunc {
+	return func(o *option) {
+		o.receiveSettings.NumGoroutines = numGoroutines
+	}
+}
+
+// SetMaxExtension option func, max duration of extending ack deadline for unacknowledged message
+func SetMaxExtension(maxExtension time.Duration) OptionFunc {
+	return func(o *option) {
+		o.receiveSettings.MaxExtension = maxExtension
+	}
+}
+
+// SetSubscriptionDryRun option func, only log diff of existing subscription attributes with handler configs
+// instead of updating the subscription
+func SetSubscriptionDryRun(dryRun bool) OptionFunc {
+	return func(o *option) {
+		o.subscriptionDryRun = dryRun
+	}
+}
+
+// SetRetryPolicy option func, backoff for redelivery of nacked message in created subscription
+func SetRetryPolicy(minimumBackoff, maximumBackoff time.Duration) OptionFunc {
+	return func(o *option) {
+		o.retryPolicy = &pubsub.RetryPolicy{
+			MinimumBackoff: minimumBackoff,
+			MaximumBackoff: maximumBackoff,
+		}
+	}
+}
+
+// SetDeadLetterPolicy option func, forward message to dead-letter topic (topic id, created if not exist)
+// after max delivery attempts (5-100) in created subscription
+func SetDeadLetterPolicy(deadLetterTopic string, maxDeliveryAttempts int) OptionFunc {
+	return func(o *option) {
+		o.deadLetterTopic = deadLetterTopic
+		o.deadLetterPolicy = &pubsub.DeadLetterPolicy{
+			MaxDeliveryAttempts: maxDeliveryAttempts,
+		}
+	}
+}
@@ -2,30 +2,131 @@ package gcppubsub
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/golangid/candi/candihelper"
 	"github.com/golangid/candi/candishared"
-	"github.com/golangid/candi/codebase/interfaces"
 	"github.com/golangid/candi/tracer"
 )
 
-type publisher struct {
-	client *pubsub.Client
+type (
+	// PublisherOptionFunc func type
+	PublisherOptionFunc func(*Publisher)
+
+	// Publisher gcp pubsub publisher, reuse topic handle (with its batching) for each topic
+	Publisher struct {
+		client   *pubsub.Client
+		settings pubsub.PublishSettings
+
+		mu     sync.Mutex
+		topics map[string]*pubsub.Topic
+	}
+)
+
+// PublisherSetPublishSettings set publish settings of topic handles
+func PublisherSetPublishSettings(settings pubsub.PublishSettings) PublisherOptionFunc {
+	return func(p *Publisher) {
+		p.settings = settings
+	}
 }
 
-// NewPublisher gcp
-func NewPublisher(client *pubsub.Client) interfaces.Publisher {
-	return &publisher{
-		client: client,
+// PublisherSetBatching set batch threshold, batch is published when one of threshold is reached
+func PublisherSetBatching(countThreshold, byteThreshold int, delayThreshold time.Duration) PublisherOptionFunc {
+	return func(p *Publisher) {
+		p.settings.CountThreshold = countThreshold
+		p.settings.ByteThreshold = byteThreshold
+		p.settings.DelayThreshold = delayThreshold
 	}
 }
 
-func (p *publisher) PublishMessage(ctx context.Context, args *candishared.PublisherArgument) (err error) {
+// PublisherSetCompression enable gzip compression for batch larger than bytes threshold
+func PublisherSetCompression(bytesThreshold int) PublisherOptionFunc {
+	return func(p *Publisher) {
+		p.settings.EnableCompression = true
+		p.settings.CompressionBytesThreshold = bytesThreshold
+	}
+}
+
+// NewPublisher gcp, message with key in publisher argument is published with ordering key
+func NewPublisher(client *pubsub.Client, opts ...PublisherOptionFunc) *Publisher {
+	p := &Publisher{
+		client:   client,
+		settings: pubsub.DefaultPublishSettings,
+		topics:   make(map[string]*pubsub.Topic),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// PublishMessage method, wait until message published
+func (p *Publisher) PublishMessage(ctx context.Context, args *candishared.PublisherArgument) (err error) {
 	trace, ctx := tracer.StartTraceWithContext(ctx, "GCPPubSub:PublishMessage")
+	defer func() { trace.Finish(tracer.FinishWithError(err)) }()
+
+	topic, message := p.newMessage(trace, args)
+	result := topic.Publish(ctx, message)
+	serverID, err := result.Get(ctx)
+	trace.Log("server_id", serverID)
+	if err != nil && message.OrderingKey != "" {
+		// publishing for ordering key is paused after failure, resume so next message can be published
+		topic.ResumePublish(message.OrderingKey)
+	}
+	return err
+}
+
+// PublishMessageAsync method, enqueue message to topic batch and return result for awaiting server id,
+// publishing for ordering key is resumed after failure without awaiting the result
+func (p *Publisher) PublishMessageAsync(ctx context.Context, args *candishared.PublisherArgument) *pubsub.PublishResult {
+	trace, ctx := tracer.StartTraceWithContext(ctx, "GCPPubSub:PublishMessageAsync")
 	defer trace.Finish()
 
+	topic, message := p.newMessage(trace, args)
+	result := topic.Publish(ctx, message)
+	if message.OrderingKey != "" {
+		go func() {
+			<-result.Ready()
+			if _, err := result.Get(context.Background()); err != nil {
+				topic.ResumePublish(message.OrderingKey)
+			}
+		}()
+	}
+	return result
+}
+
+// Close flush pending messages and stop all topic handles
+func (p *Publisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	topics := p.topics
+	p.topics = make(map[string]*pubsub.Topic)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, topic := range topics {
+		wg.Add(1)
+		go func(topic *pubsub.Topic) {
+			defer wg.Done()
+			topic.Stop()
+		}(topic)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Publisher) newMessage(trace tracer.Tracer, args *candishared.PublisherArgument) (*pubsub.Topic, *pubsub.Message) {
 	var msg []byte
 	if len(args.Message) > 0 {
 		msg = args.Message
@@ -43,8 +144,25 @@ func (p *publisher) PublishMessage(ctx context.Context, args *candishared.Publis
 		message.Attributes[k] = string(candihelper.ToBytes(v))
 	}
 
-	result := p.client.Topic(args.Topic).Publish(ctx, message)
-	serverID, err := result.Get(ctx)
-	trace.Log("server_id", serverID)
-	return err
+	trace.SetTag("topic", args.Topic)
+	if args.Key != "" {
+		message.OrderingKey = args.Key
+		trace.SetTag("ordering_key", args.Key)
+	}
+	return p.getTopic(args.Topic), message
+}
+
+// getTopic reuse topic handle, ordering is only guaranteed within same topic handle
+func (p *Publisher) getTopic(topicName string) *pubsub.Topic {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	topic, ok := p.topics[topicName]
+	if !ok {
+		topic = p.client.Topic(topicName)
+		topic.PublishSettings = p.settings
+		topic.EnableMessageOrdering = true
+		p.topics[topicName] = topic
+	}
+	return topic
 }
@@ -0,0 +1,137 @@
+package gcppubsub
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/golangid/candi/codebase/factory/types"
+	"github.com/golangid/candi/logger"
+)
+
+const (
+	defaultAckDeadline = 20 * time.Second
+)
+
+// subscriptionConfig subscription attributes declared in handler configs and worker options
+func (w *workerEngine) subscriptionConfig(topic *pubsub.Topic, handler types.WorkerHandler) pubsub.SubscriptionConfig {
+	cfg := pubsub.SubscriptionConfig{
+		Topic:            topic,
+		AckDeadline:      defaultAckDeadline,
+		RetryPolicy:      w.opt.retryPolicy,
+		DeadLetterPolicy: w.opt.deadLetterPolicy,
+	}
+	cfg.EnableMessageOrdering, _ = handler.Configs[ConfigEnableMessageOrdering].(bool)
+	cfg.Filter, _ = handler.Configs[ConfigFilter].(string)
+	cfg.EnableExactlyOnceDelivery, _ = handler.Configs[ConfigEnableExactlyOnceDelivery].(bool)
+	if ackDeadline, ok := handler.Configs[ConfigAckDeadline].(time.Duration); ok {
+		cfg.AckDeadline = ackDeadline
+	}
+	if retention, ok := handler.Configs[ConfigRetentionDuration].(time.Duration); ok {
+		cfg.RetentionDuration = retention
+	}
+	if expiration, ok := handler.Configs[ConfigExpirationPolicy].(time.Duration); ok {
+		cfg.ExpirationPolicy = expiration
+	}
+	if endpoint, ok := handler.Configs[ConfigPushEndpoint].(string); ok && endpoint != "" {
+		cfg.PushConfig = pubsub.PushConfig{Endpoint: endpoint}
+		if serviceAccount, ok := handler.Configs[ConfigPushServiceAccount].(string); ok && serviceAccount != "" {
+			cfg.PushConfig.AuthenticationMethod = &pubsub.OIDCToken{ServiceAccountEmail: serviceAccount}
+		}
+	}
+	return cfg
+}
+
+// reconcileSubscription update existing subscription attributes which declared in handler configs (or worker options)
+// and differ from current attributes, only log the diff if dry run enabled
+func (w *workerEngine) reconcileSubscription(sub *pubsub.Subscription, desired pubsub.SubscriptionConfig, handler types.WorkerHandler) {
+	current, err := sub.Config(w.ctx)
+	if err != nil {
+		panic("GCP PubSub get subscriber config " + sub.ID() + ": " + err.Error())
+	}
+
+	var (
+		update pubsub.SubscriptionConfigToUpdate
+		diffs  []string
+	)
+	addDiff := func(field string, from, to interface{}) {
+		diffs = append(diffs, fmt.Sprintf("%s: %v -> %v", field, from, to))
+	}
+
+	if _, ok := handler.Configs[ConfigAckDeadline]; ok && current.AckDeadline != desired.AckDeadline {
+		update.AckDeadline = desired.AckDeadline
+		addDiff("ack_deadline", current.AckDeadline, desired.AckDeadline)
+	}
+	if _, ok := handler.Configs[ConfigRetentionDuration]; ok && current.RetentionDuration != desired.RetentionDuration {
+		update.RetentionDuration = desired.RetentionDuration
+		addDiff("retention_duration", current.RetentionDuration, desired.RetentionDuration)
+	}
+	if _, ok := handler.Configs[ConfigExpirationPolicy]; ok &&
+		durationValue(current.ExpirationPolicy) != durationValue(desired.ExpirationPolicy) {
+		update.ExpirationPolicy = desired.ExpirationPolicy
+		addDiff("expiration_policy", durationValue(current.ExpirationPolicy), durationValue(desired.ExpirationPolicy))
+	}
+	if _, ok := handler.Configs[ConfigEnableExactlyOnceDelivery]; ok && current.EnableExactlyOnceDelivery != desired.EnableExactlyOnceDelivery {
+		update.EnableExactlyOnceDelivery = desired.EnableExactlyOnceDelivery
+		addDiff("enable_exactly_once_delivery", current.EnableExactlyOnceDelivery, desired.EnableExactlyOnceDelivery)
+	}
+	if _, ok := handler.Configs[ConfigPushEndpoint]; ok && current.PushConfig.Endpoint != desired.PushConfig.Endpoint {
+		update.PushConfig = &desired.PushConfig
+		addDiff("push_endpoint", current.PushConfig.Endpoint, desired.PushConfig.Endpoint)
+	}
+	if desired.RetryPolicy != nil && (current.RetryPolicy == nil ||
+		durationValue(current.RetryPolicy.MinimumBackoff) != durationValue(desired.RetryPolicy.MinimumBackoff) ||
+		durationValue(current.RetryPolicy.MaximumBackoff) != durationValue(desired.RetryPolicy.MaximumBackoff)) {
+		update.RetryPolicy = desired.RetryPolicy
+		addDiff("retry_policy", retryPolicyString(current.RetryPolicy), retryPolicyString(desired.RetryPolicy))
+	}
+	if desired.DeadLetterPolicy != nil && (current.DeadLetterPolicy == nil ||
+		current.DeadLetterPolicy.DeadLetterTopic != desired.DeadLetterPolicy.DeadLetterTopic ||
+		current.DeadLetterPolicy.MaxDeliveryAttempts != desired.DeadLetterPolicy.MaxDeliveryAttempts) {
+		update.DeadLetterPolicy = desired.DeadLetterPolicy
+		addDiff("dead_letter_policy", deadLetterPolicyString(current.DeadLetterPolicy), deadLetterPolicyString(desired.DeadLetterPolicy))
+	}
+
+	// immutable attributes, subscription must be recreated for applying the change
+	if _, ok := handler.Configs[ConfigFilter]; ok && current.Filter != desired.Filter {
+		logger.LogRed(fmt.Sprintf("GCP PubSub subscriber %s: filter %q -> %q can not be updated, recreate subscription to apply",
+			sub.ID(), current.Filter, desired.Filter))
+	}
+	if _, ok := handler.Configs[ConfigEnableMessageOrdering]; ok && current.EnableMessageOrdering != desired.EnableMessageOrdering {
+		logger.LogRed(fmt.Sprintf("GCP PubSub subscriber %s: enable_message_ordering %v -> %v can not be updated, recreate subscription to apply",
+			sub.ID(), current.EnableMessageOrdering, desired.EnableMessageOrdering))
+	}
+
+	if len(diffs) == 0 {
+		return
+	}
+	if w.opt.subscriptionDryRun {
+		logger.LogYellow(fmt.Sprintf("GCP PubSub subscriber %s (dry run): %s", sub.ID(), strings.Join(diffs, ", ")))
+		return
+	}
+	if _, err := sub.Update(w.ctx, update); err != nil {
+		panic("GCP PubSub update subscriber " + sub.ID() + ": " + err.Error())
+	}
+	logger.LogYellow(fmt.Sprintf("GCP PubSub subscriber %s updated: %s", sub.ID(), strings.Join(diffs, ", ")))
+}
+
+// durationValue optional duration value, zero if not set
+func durationValue(v interface{}) time.Duration {
+	d, _ := v.(time.Duration)
+	return d
+}
+
+func retryPolicyString(p *pubsub.RetryPolicy) string {
+	if p == nil {
+		return "none"
+	}
+	return fmt.Sprintf("{min_backoff: %s, max_backoff: %s}", durationValue(p.MinimumBackoff), durationValue(p.MaximumBackoff))
+}
+
+func deadLetterPolicyString(p *pubsub.DeadLetterPolicy) string {
+	if p == nil {
+		return "none"
+	}
+	return fmt.Sprintf("{topic: %s, max_delivery_attempts: %d}", p.DeadLetterTopic, p.MaxDeliveryAttempts)
+}
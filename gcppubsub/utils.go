@@ -10,3 +10,9 @@ import (
 func GetMessageAttributes(ctx context.Context) map[string]string {
 	return candishared.GetValueFromContext(ctx, MessageAttribute).(map[string]string)
 }
+
+// GetDeliveryAttempt get delivery attempt of consumed message from context, zero if subscription has no dead-letter policy
+func GetDeliveryAttempt(ctx context.Context) int {
+	attempt, _ := candishared.GetValueFromContext(ctx, DeliveryAttempt).(int)
+	return attempt
+}
@@ -2,6 +2,9 @@ package stompbroker
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-stomp/stomp/v3"
@@ -20,6 +23,36 @@ func BrokerSetConn(conn *stomp.Conn) BrokerOptionFunc {
 	}
 }
 
+// BrokerSetDialer set dial func for reconnecting when connection to server is lost,
+// connection is dialed on broker setup if conn is nil
+func BrokerSetDialer(dial DialFunc) BrokerOptionFunc {
+	return func(bk *Broker) {
+		bk.dial = dial
+	}
+}
+
+// BrokerSetPublisherFailFast set default publisher return ErrDisconnected instead of waiting reconnect while disconnected
+func BrokerSetPublisherFailFast(failFast bool) BrokerOptionFunc {
+	return func(bk *Broker) {
+		bk.publisherFailFast = failFast
+	}
+}
+
+// BrokerSetHealthCheckDestination enable health check by sending message with receipt to destination
+// (e.g. topic without subscriber), default is empty and only check connection state
+func BrokerSetHealthCheckDestination(destination string) BrokerOptionFunc {
+	return func(bk *Broker) {
+		bk.healthCheckDestination = destination
+	}
+}
+
+// BrokerSetHealthCheckTimeout set timeout for awaiting health check receipt
+func BrokerSetHealthCheckTimeout(timeout time.Duration) BrokerOptionFunc {
+	return func(bk *Broker) {
+		bk.healthCheckTimeout = timeout
+	}
+}
+
 // BrokerSetPublisher set custom publisher
 func BrokerSetPublisher(pub interfaces.Publisher) BrokerOptionFunc {
 	return func(bk *Broker) {
@@ -29,33 +62,50 @@ func BrokerSetPublisher(pub interfaces.Publisher) BrokerOptionFunc {
 
 // InitDefaultConnection stomp
 func InitDefaultConnection(broker, username, password string) *stomp.Conn {
-	conn, err := stomp.Dial("tcp", broker,
-		stomp.ConnOpt.Login(username, password),
-		stomp.ConnOpt.Host("/"),
-		stomp.ConnOpt.HeartBeatError(360*time.Second),
-		stomp.ConnOpt.HeartBeatGracePeriodMultiplier(3),
-	)
+	conn, err := DefaultDialer(broker, username, password)()
 	if err != nil {
 		panic("STOMP: cannot connect to server broker: " + err.Error())
 	}
 	return conn
 }
 
+// DefaultDialer dial func with default connection options, use with BrokerSetDialer for enabling reconnect
+func DefaultDialer(broker, username, password string) DialFunc {
+	return func() (*stomp.Conn, error) {
+		return stomp.Dial("tcp", broker,
+			stomp.ConnOpt.Login(username, password),
+			stomp.ConnOpt.Host("/"),
+			stomp.ConnOpt.HeartBeatError(360*time.Second),
+			stomp.ConnOpt.HeartBeatGracePeriodMultiplier(3),
+		)
+	}
+}
+
 // NewSTOMPBroker setup STOMP broker for publisher or consumer
 func NewSTOMPBroker(conn *stomp.Conn, opts ...BrokerOptionFunc) *Broker {
 	deferFunc := logger.LogWithDefer("Load STOMP broker configuration... ")
 	defer deferFunc()
 
 	stompBroker := &Broker{
-		WorkerType: STOMPBroker,
-		Conn:       conn,
+		WorkerType:         STOMPBroker,
+		Conn:               conn,
+		healthCheckTimeout: 5 * time.Second,
 	}
 	for _, opt := range opts {
 		opt(stompBroker)
 	}
 
+	if stompBroker.Conn == nil && stompBroker.dial != nil {
+		conn, err := stompBroker.dial()
+		if err != nil {
+			panic("STOMP: cannot connect to server broker: " + err.Error())
+		}
+		stompBroker.Conn = conn
+	}
+
+	stompBroker.conn = newConnection(stompBroker.Conn, stompBroker.dial)
 	if stompBroker.publisher == nil {
-		stompBroker.publisher = NewPublisher(stompBroker.Conn)
+		stompBroker.publisher = &publisher{conn: stompBroker.conn, failFast: stompBroker.publisherFailFast}
 	}
 
 	return stompBroker
@@ -64,8 +114,36 @@ func NewSTOMPBroker(conn *stomp.Conn, opts ...BrokerOptionFunc) *Broker {
 // Broker stomp
 type Broker struct {
 	WorkerType types.Worker
-	Conn       *stomp.Conn
-	publisher  interfaces.Publisher
+	// Conn initial connection, connection is replaced after reconnect so use GetConn for current connection
+	Conn      *stomp.Conn
+	publisher interfaces.Publisher
+
+	conn              *connection
+	dial              DialFunc
+	publisherFailFast bool
+
+	healthCheckDestination string
+	healthCheckTimeout     time.Duration
+	healthCheckPending     atomic.Bool
+
+	mu          sync.Mutex
+	subscribers []subscriber
+}
+
+// subscriber consumer using broker connection, unsubscribed on broker disconnect
+type subscriber interface {
+	unsubscribe(ctx context.Context) error
+}
+
+func (s *Broker) addSubscriber(sub subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, sub)
+}
+
+// GetConn current connection, nil while reconnecting
+func (s *Broker) GetConn() *stomp.Conn {
+	return s.conn.current()
 }
 
 // GetPublisher method
@@ -78,20 +156,82 @@ func (s *Broker) GetName() types.Worker {
 	return STOMPBroker
 }
 
-// Health method
+// Health method, check connection state and send health check message with receipt if destination is set
+// so broken connection (e.g. heartbeat timeout) is detected and reconnected
 func (s *Broker) Health() map[string]error {
-
-	// TODO: add health check from client connection
-	var err error
 	return map[string]error{
-		string(STOMPBroker): err,
+		string(STOMPBroker): s.checkHealth(),
+	}
+}
+
+func (s *Broker) checkHealth() error {
+	if !s.conn.isConnected() {
+		return ErrDisconnected
+	}
+	conn := s.conn.current()
+	if conn == nil {
+		return ErrDisconnected
+	}
+	if s.healthCheckDestination == "" {
+		return nil
+	}
+
+	// send with receipt is blocking until server reply, so await in background with timeout,
+	// only one send in flight so unanswered send is not piling up
+	if !s.healthCheckPending.CompareAndSwap(false, true) {
+		return errors.New("stomp: previous health check receipt is still pending")
+	}
+	result := make(chan error, 1)
+	go func() {
+		defer s.healthCheckPending.Store(false)
+		result <- conn.Send(s.healthCheckDestination, "text/plain", nil,
+			stomp.SendOpt.Receipt, stomp.SendOpt.Header(HeaderExpires, headerValue(HeaderExpires, s.healthCheckTimeout)),
+		)
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			s.conn.markBroken(conn)
+		}
+		return err
+	case <-time.After(s.healthCheckTimeout):
+		// disconnecting broken connection also unblock pending send
+		s.conn.markBroken(conn)
+		return errors.New("stomp: health check receipt timeout")
 	}
 }
 
-// Disconnect method
+// Disconnect method, unsubscribe all subscriptions, wait in-flight handlers and disconnect from server
+// within context deadline
 func (s *Broker) Disconnect(ctx context.Context) error {
 	deferFunc := logger.LogWithDefer("stomp broker: disconnect...")
 	defer deferFunc()
 
-	return nil
+	s.mu.Lock()
+	subscribers := s.subscribers
+	s.mu.Unlock()
+
+	var errs []error
+	for _, sub := range subscribers {
+		if err := sub.unsubscribe(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	conn := s.conn.close()
+	if conn == nil {
+		return errors.Join(errs...)
+	}
+
+	// graceful disconnect wait receipt from server, force close when context deadline exceeded
+	done := make(chan error, 1)
+	go func() { done <- conn.Disconnect() }()
+	select {
+	case err := <-done:
+		errs = append(errs, err)
+	case <-ctx.Done():
+		errs = append(errs, conn.MustDisconnect(), ctx.Err())
+	}
+	return errors.Join(errs...)
 }
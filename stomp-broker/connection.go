@@ -0,0 +1,160 @@
+package stompbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/golangid/candi/logger"
+)
+
+const (
+	reconnectMinBackoff = 1 * time.Second
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+var (
+	// ErrDisconnected returned by publisher when connection to server is lost and publisher fail fast enabled
+	ErrDisconnected = errors.New("stomp: disconnected from server")
+)
+
+// DialFunc func type for connecting (and reconnecting) to stomp server
+type DialFunc func() (*stomp.Conn, error)
+
+// connection hold current stomp connection and re-dial in background with backoff when connection is lost
+type connection struct {
+	dial DialFunc
+
+	mu     sync.Mutex
+	conn   *stomp.Conn
+	ready  chan struct{} // closed when conn is connected
+	broken bool          // connection lost and cannot be reconnected (no dial func)
+	closed bool
+}
+
+func newConnection(conn *stomp.Conn, dial DialFunc) *connection {
+	c := &connection{
+		dial:  dial,
+		conn:  conn,
+		ready: make(chan struct{}),
+	}
+	close(c.ready)
+	return c
+}
+
+// get current connection, wait until reconnected when connection is lost
+func (c *connection) get(ctx context.Context) (*stomp.Conn, error) {
+	for {
+		c.mu.Lock()
+		conn, ready, closed, broken := c.conn, c.ready, c.closed, c.broken
+		c.mu.Unlock()
+
+		if closed || broken {
+			return nil, ErrDisconnected
+		}
+		select {
+		case <-ready:
+			return conn, nil
+		default:
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// current connection without waiting, nil if reconnecting
+func (c *connection) current() *stomp.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// isConnected check connection is usable, false if reconnecting, broken or closed
+func (c *connection) isConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil && !c.broken && !c.closed
+}
+
+// canReconnect check dial func has been set
+func (c *connection) canReconnect() bool {
+	return c.dial != nil
+}
+
+// markBroken start reconnecting in background, ignored if broken connection has been replaced.
+// Without dial func connection is kept broken, so health check and publisher report ErrDisconnected
+func (c *connection) markBroken(broken *stomp.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.broken || broken == nil || c.conn != broken {
+		return
+	}
+	if c.dial == nil {
+		c.broken = true
+		go broken.MustDisconnect()
+		return
+	}
+
+	c.conn = nil
+	c.ready = make(chan struct{})
+	go broken.MustDisconnect()
+	go c.redial(c.ready)
+}
+
+// close mark connection closed and stop reconnecting, return current connection for disconnecting
+func (c *connection) close() *stomp.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	select {
+	case <-c.ready:
+	default:
+		// release waiters of reconnecting connection, they get ErrDisconnected
+		close(c.ready)
+	}
+	conn := c.conn
+	c.conn = nil
+	return conn
+}
+
+func (c *connection) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *connection) redial(ready chan struct{}) {
+	backoff := reconnectMinBackoff
+	for !c.isClosed() {
+		conn, err := c.dial()
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				conn.MustDisconnect()
+				return
+			}
+			c.conn = conn
+			close(ready)
+			c.mu.Unlock()
+			logger.LogGreen("stomp > reconnected to server " + conn.Server())
+			return
+		}
+
+		logger.LogRed(fmt.Sprintf("stomp > reconnect to server: %s, retry in %s", err.Error(), backoff))
+		time.Sleep(backoff)
+		backoff = min(backoff*2, reconnectMaxBackoff)
+	}
+}
@@ -1,13 +1,17 @@
 package stompbroker
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
 	"reflect"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/golangid/candi/candishared"
 	"github.com/golangid/candi/codebase/factory"
 	"github.com/golangid/candi/codebase/factory/types"
@@ -20,16 +24,32 @@ type workerEngine struct {
 	ctx           context.Context
 	ctxCancelFunc func()
 
-	service    factory.ServiceFactory
-	channels   []reflect.SelectCase
-	semaphore  map[string]chan struct{}
-	shutdown   chan struct{}
-	isShutdown bool
-	wg         sync.WaitGroup
-	opt        option
+	// receiverCtx only control the receive loop, handler keep using ctx until all running jobs done
+	receiverCtx        context.Context
+	receiverCancelFunc func()
+	serveDone          chan struct{}
+
+	service  factory.ServiceFactory
+	channels []reflect.SelectCase
+	wg       sync.WaitGroup
+	opt      option
+
+	bk              *Broker
+	conn            *stomp.Conn
+	handlers        map[string]types.WorkerHandler
+	subscriptions   []*subscription
+	unsubscribeOnce sync.Once
+}
 
-	bk       *Broker
-	handlers map[string]types.WorkerHandler
+// subscription handler subscription, channels[i+1] receive from subscriptions[i],
+// message is routed by subscription because destination of message can differ from subscribed pattern (wildcard)
+type subscription struct {
+	handler       types.WorkerHandler
+	destination   string
+	headers       map[string]string
+	maxGoroutines int
+	semaphore     chan struct{}
+	sub           *stomp.Subscription
 }
 
 // NewSTOMPWorker create new stomp client worker for subscribe from queue
@@ -48,11 +68,15 @@ func NewSTOMPWorker(service factory.ServiceFactory, broker interfaces.Broker, op
 	for _, opt := range opts {
 		opt(&worker.opt)
 	}
+	if worker.opt.maxGoroutines <= 0 {
+		// zero size semaphore deadlock receive loop and prefetch size 0 is invalid
+		worker.opt.maxGoroutines = getDefaultOption().maxGoroutines
+	}
 
 	worker.ctx, worker.ctxCancelFunc = context.WithCancel(context.Background())
-	worker.shutdown = make(chan struct{}, 1)
+	worker.receiverCtx, worker.receiverCancelFunc = context.WithCancel(context.Background())
+	worker.serveDone = make(chan struct{})
 	worker.handlers = make(map[string]types.WorkerHandler)
-	worker.semaphore = make(map[string]chan struct{})
 
 	for _, m := range service.GetModules() {
 		if h := m.WorkerHandler(worker.bk.WorkerType); h != nil {
@@ -64,55 +88,74 @@ func NewSTOMPWorker(service factory.ServiceFactory, broker interfaces.Broker, op
 						getWorkerTypeLog(worker.bk.WorkerType), handler.Pattern)
 				}
 
-				worker.handlers[handler.Pattern] = handler
-				sub, err := worker.bk.Conn.Subscribe(handler.Pattern, stomp.AckClient)
-				if err != nil {
-					log.Panicf("STOMP%s: cannot subscribe to %s: %s", getWorkerTypeLog(worker.bk.WorkerType), handler.Pattern, err.Error())
+				maxGoroutines, ok := handler.Configs[ConfigMaxGoroutines].(int)
+				if !ok || maxGoroutines <= 0 {
+					maxGoroutines = worker.opt.maxGoroutines
 				}
 
-				logger.LogYellow(fmt.Sprintf("[STOMP-WORKER]%s (topic): %-8s  (consumed by module)--> [%s]",
-					getWorkerTypeLog(worker.bk.WorkerType), handler.Pattern, m.Name()))
+				s := newSubscription(handler, maxGoroutines)
+				worker.handlers[handler.Pattern] = handler
+				worker.subscriptions = append(worker.subscriptions, s)
 
-				worker.channels = append(worker.channels, reflect.SelectCase{
-					Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sub.C),
-				})
-				worker.semaphore[handler.Pattern] = make(chan struct{}, 1)
+				logger.LogYellow(fmt.Sprintf("[STOMP-WORKER]%s (topic): %-8s  (consumed by module)--> [%s]",
+					getWorkerTypeLog(worker.bk.WorkerType), s.destination, m.Name()))
 			}
 		}
 	}
 
+	conn, err := worker.bk.conn.get(worker.ctx)
+	if err != nil {
+		log.Panicf("STOMP%s: cannot get connection: %s", getWorkerTypeLog(worker.bk.WorkerType), err.Error())
+	}
+	if err := worker.subscribe(conn); err != nil {
+		log.Panicf("STOMP%s: %s", getWorkerTypeLog(worker.bk.WorkerType), err.Error())
+	}
+
+	worker.bk.addSubscriber(worker)
+
 	fmt.Printf("\x1b[34;1m⇨ STOMP worker%s running with %d topics. Broker: %s\x1b[0m\n\n",
-		getWorkerTypeLog(worker.bk.WorkerType), len(worker.handlers), worker.bk.Conn.Server())
+		getWorkerTypeLog(worker.bk.WorkerType), len(worker.handlers), conn.Server())
 	return worker
 }
 
 func (w *workerEngine) Serve() {
+	defer close(w.serveDone)
+
 	for {
-		select {
-		case <-w.shutdown:
+		chosen, value, ok := reflect.Select(w.channels)
+		if chosen == 0 {
+			// worker shutdown
 			return
-
-		default:
 		}
 
-		chosen, value, ok := reflect.Select(w.channels)
-		if !ok {
+		msg, _ := value.Interface().(*stomp.Message)
+		if !ok || msg == nil || msg.Err != nil {
+			// subscription closed or receive error frame, the connection is no longer usable
+			var cause error = stomp.ErrClosedUnexpectedly
+			if msg != nil && msg.Err != nil {
+				cause = msg.Err
+			}
+			if !w.reconnect(cause) {
+				return
+			}
 			continue
 		}
 
-		if msg, ok := value.Interface().(*stomp.Message); ok {
-			w.semaphore[msg.Destination] <- struct{}{}
-			if w.isShutdown {
-				return
-			}
+		s := w.subscriptions[chosen-1]
+		select {
+		case s.semaphore <- struct{}{}:
+		case <-w.receiverCtx.Done():
+			return
+		}
 
-			w.wg.Add(1)
-			go func(idx int, message *stomp.Message) {
-				w.processMessage(message)
+		w.wg.Add(1)
+		go func(s *subscription, message *stomp.Message) {
+			defer func() {
 				w.wg.Done()
-				<-w.semaphore[message.Destination]
-			}(chosen, msg)
-		}
+				<-s.semaphore
+			}()
+			w.processMessage(s.handler, message)
+		}(s, msg)
 	}
 }
 
@@ -122,24 +165,136 @@ func (w *workerEngine) Shutdown(ctx context.Context) {
 		log.Printf("\x1b[33;1mStopping STOMP Worker%s:\x1b\n \x1b[32;1mSUCCESS\x1b[0m\n", getWorkerTypeLog(w.bk.WorkerType))
 	}()
 
-	w.shutdown <- struct{}{}
-	w.isShutdown = true
+	w.receiverCancelFunc()
+	<-w.serveDone
+
 	runningJob := 0
-	for _, sem := range w.semaphore {
-		runningJob += len(sem)
+	for _, s := range w.subscriptions {
+		runningJob += len(s.semaphore)
 	}
 	if runningJob != 0 {
 		fmt.Printf("\x1b[34;1mSTOMP Worker%s:\x1b[0m waiting %d job until done...\x1b[0m\n", getWorkerTypeLog(w.bk.WorkerType), runningJob)
 	}
 
 	w.wg.Wait()
+	w.unsubscribeAll()
+	w.ctxCancelFunc()
 }
 
 func (w *workerEngine) Name() string {
 	return string(w.bk.WorkerType)
 }
 
-func (w *workerEngine) processMessage(msg *stomp.Message) {
+// subscribe all handlers using given connection and rebuild select cases
+func (w *workerEngine) subscribe(conn *stomp.Conn) error {
+	channels := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(w.receiverCtx.Done())},
+	}
+	for _, s := range w.subscriptions {
+		// cumulative ack (client mode) would also ack/nack other in-flight messages, so ack each message individually
+		// and limit broker dispatch to max in-flight messages
+		opts := []func(*frame.Frame) error{
+			stomp.SubscribeOpt.Header(HeaderActiveMQPrefetchSize, strconv.Itoa(s.maxGoroutines)),
+		}
+		for k, v := range s.headers {
+			opts = append(opts, stomp.SubscribeOpt.Header(k, v))
+		}
+		sub, err := conn.Subscribe(s.destination, stomp.AckClientIndividual, opts...)
+		if err != nil {
+			return fmt.Errorf("cannot subscribe to %s: %w", s.destination, err)
+		}
+		s.sub = sub
+		channels = append(channels, reflect.SelectCase{
+			Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sub.C),
+		})
+	}
+
+	w.conn = conn
+	w.channels = channels
+	return nil
+}
+
+// unsubscribe stop receiving, wait running jobs until done then unsubscribe all handlers, called on broker disconnect
+func (w *workerEngine) unsubscribe(ctx context.Context) error {
+	w.receiverCancelFunc()
+	select {
+	case <-w.serveDone:
+	case <-ctx.Done():
+		return fmt.Errorf("STOMP Worker%s: stop receiving: %w", getWorkerTypeLog(w.bk.WorkerType), ctx.Err())
+	}
+
+	jobsDone := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		return fmt.Errorf("STOMP Worker%s: waiting running jobs: %w", getWorkerTypeLog(w.bk.WorkerType), ctx.Err())
+	}
+
+	w.unsubscribeAll()
+	return nil
+}
+
+// unsubscribeAll unsubscribe after running jobs done, so their messages can still be acknowledged
+func (w *workerEngine) unsubscribeAll() {
+	w.unsubscribeOnce.Do(func() {
+		if w.conn == nil || w.conn != w.bk.conn.current() {
+			// subscriptions on broken connection are already gone
+			return
+		}
+		// unsubscribe frame only contains subscription id, so durable subscription is kept by broker
+		for _, s := range w.subscriptions {
+			if s.sub == nil || !s.sub.Active() {
+				continue
+			}
+			if err := s.sub.Unsubscribe(); err != nil {
+				logger.LogRed(fmt.Sprintf("STOMP Worker%s: unsubscribe %s: %s", getWorkerTypeLog(w.bk.WorkerType), s.handler.Pattern, err.Error()))
+			}
+		}
+	})
+}
+
+// reconnect wait until broker connection recovered then resubscribe all handlers,
+// return false if worker shutdown, panic if reconnect is not available so worker is not dead silently
+func (w *workerEngine) reconnect(cause error) bool {
+	logger.LogRed(fmt.Sprintf("STOMP Worker%s: connection lost: %s", getWorkerTypeLog(w.bk.WorkerType), cause.Error()))
+	if !w.bk.conn.canReconnect() {
+		// keep broken state so health check report disconnected
+		w.bk.conn.markBroken(w.conn)
+		if w.receiverCtx.Err() != nil {
+			return false
+		}
+		log.Panicf("STOMP Worker%s: connection lost and reconnect is not available, set dial func with BrokerSetDialer: %s",
+			getWorkerTypeLog(w.bk.WorkerType), cause.Error())
+	}
+
+	broken := w.conn
+	for {
+		w.bk.conn.markBroken(broken)
+		conn, err := w.bk.conn.get(w.receiverCtx)
+		if err != nil {
+			return false
+		}
+		if err := w.subscribe(conn); err != nil {
+			logger.LogRed(fmt.Sprintf("STOMP Worker%s: resubscribe: %s", getWorkerTypeLog(w.bk.WorkerType), err.Error()))
+			broken = conn
+			select {
+			case <-time.After(reconnectMinBackoff):
+			case <-w.receiverCtx.Done():
+				return false
+			}
+			continue
+		}
+
+		logger.LogGreen(fmt.Sprintf("STOMP Worker%s: resubscribed %d topics", getWorkerTypeLog(w.bk.WorkerType), len(w.subscriptions)))
+		return true
+	}
+}
+
+func (w *workerEngine) processMessage(selectedHandler types.WorkerHandler, msg *stomp.Message) {
 	if w.ctx.Err() != nil {
 		logger.LogRed(w.Name() + " > ctx root err: " + w.ctx.Err().Error())
 		return
@@ -155,7 +310,6 @@ func (w *workerEngine) processMessage(msg *stomp.Message) {
 	}
 
 	ctx := w.ctx
-	selectedHandler := w.handlers[msg.Destination]
 	if selectedHandler.DisableTrace {
 		ctx = tracer.SkipTraceContext(ctx)
 	}
@@ -166,14 +320,16 @@ func (w *workerEngine) processMessage(msg *stomp.Message) {
 		header[k] = v
 	}
 
-	trace, ctx := tracer.StartTraceFromHeader(w.ctx, "STOMPWorker", header)
+	var err error
+	trace, ctx := tracer.StartTraceFromHeader(ctx, "STOMPWorker", header)
 	defer func() {
 		if r := recover(); r != nil {
-			trace.SetError(fmt.Errorf("panic: %v", r))
+			err = fmt.Errorf("panic: %v", r)
+			trace.SetError(err)
 		}
 
 		if selectedHandler.AutoACK {
-			msg.Conn.Ack(msg)
+			w.acknowledgeMessage(msg, selectedHandler, err)
 		}
 		logger.LogGreen(w.Name() + " > trace_url: " + tracer.GetTraceURL(ctx))
 		trace.SetTag("trace_id", tracer.GetTraceID(ctx))
@@ -189,11 +345,13 @@ func (w *workerEngine) processMessage(msg *stomp.Message) {
 	}
 	trace.SetTag("broker", msg.Conn.Server())
 	trace.SetTag("destination", msg.Destination)
+	trace.SetTag("handler", selectedHandler.Pattern)
 	trace.SetTag("content-type", msg.ContentType)
 	trace.Log("message.body", msg.Body)
 
-	var eventContext candishared.EventContext
-	eventContext.SetContext(ctx)
+	eventContext := candishared.NewEventContext(bytes.NewBuffer(make([]byte, 0, 256)))
+	// consumed message for replying request with Reply/ReplyError
+	eventContext.SetContext(context.WithValue(ctx, stompMessageKey, msg))
 	eventContext.SetWorkerType(w.Name())
 	eventContext.SetHandlerRoute(msg.Destination)
 	eventContext.SetHeader(header)
@@ -201,13 +359,102 @@ func (w *workerEngine) processMessage(msg *stomp.Message) {
 	eventContext.Write(msg.Body)
 
 	for _, handlerFunc := range selectedHandler.HandlerFuncs {
-		if err := handlerFunc(&eventContext); err != nil {
+		if handlerErr := handlerFunc(eventContext); handlerErr != nil {
+			err = handlerErr
 			eventContext.SetError(err)
 			trace.SetError(err)
 		}
 	}
 }
 
+// acknowledgeMessage ack message if handler succeed, otherwise nack message for redelivery
+// or forward to dead-letter destination after max attempts exceeded
+func (w *workerEngine) acknowledgeMessage(msg *stomp.Message, handler types.WorkerHandler, handlerErr error) {
+	if handlerErr == nil {
+		if err := msg.Conn.Ack(msg); err != nil {
+			logger.LogRed(w.Name() + " > ack message: " + err.Error())
+		}
+		return
+	}
+
+	attempt, counted := deliveryAttempt(msg.Header)
+	maxAttempts, _ := handler.Configs[ConfigMaxAttempts].(int)
+	if maxAttempts > 2 && !counted {
+		// broker only flag redelivered message (e.g. RabbitMQ) and nacked message is requeued immediately,
+		// so forward to dead-letter destination on first failed redelivery instead of redelivering forever
+		maxAttempts = 2
+	}
+	deadLetterDestination, _ := handler.Configs[ConfigDeadLetterDestination].(string)
+	if maxAttempts > 0 && attempt >= maxAttempts && deadLetterDestination != "" {
+		err := w.sendToDeadLetter(deadLetterDestination, msg, attempt, handlerErr)
+		if err == nil {
+			err = msg.Conn.Ack(msg)
+		}
+		if err == nil {
+			return
+		}
+		logger.LogRed(fmt.Sprintf("%s > forward message to dead-letter destination '%s': %s", w.Name(), deadLetterDestination, err.Error()))
+	}
+
+	if err := msg.Conn.Nack(msg); err != nil {
+		logger.LogRed(w.Name() + " > nack message: " + err.Error())
+	}
+}
+
+// sendToDeadLetter send original body and headers with error headers to dead-letter destination
+func (w *workerEngine) sendToDeadLetter(destination string, msg *stomp.Message, attempt int, handlerErr error) error {
+	opts := []func(*frame.Frame) error{
+		stomp.SendOpt.Header(StompErrorHeader, handlerErr.Error()),
+		stomp.SendOpt.Header(StompOriginalDestinationHeader, msg.Destination),
+		stomp.SendOpt.Header(StompDeliveryAttemptHeader, strconv.Itoa(attempt)),
+	}
+	for i := 0; i < msg.Header.Len(); i++ {
+		k, v := msg.Header.GetAt(i)
+		switch k {
+		case frame.Destination, frame.MessageId, frame.Subscription, frame.Ack, frame.ContentLength, frame.ContentType,
+			// dead-letter message is new message, so it must not expire with original ttl or carry original delivery state
+			HeaderExpires, HeaderAMQScheduledDelay, HeaderRedelivered, HeaderRedeliveryCounter, HeaderJMSXDeliveryCount,
+			StompErrorHeader, StompOriginalDestinationHeader, StompDeliveryAttemptHeader:
+			continue
+		}
+		opts = append(opts, stomp.SendOpt.Header(k, v))
+	}
+	return msg.Conn.Send(destination, msg.ContentType, msg.Body, opts...)
+}
+
+// newSubscription subscribe destination and headers from handler configs, handler pattern is subscribed destination
+// (can be wildcard, e.g. /topic/orders.>) if destination config is not set
+func newSubscription(handler types.WorkerHandler, maxGoroutines int) *subscription {
+	s := &subscription{
+		handler:       handler,
+		destination:   handler.Pattern,
+		headers:       make(map[string]string),
+		maxGoroutines: maxGoroutines,
+		semaphore:     make(chan struct{}, maxGoroutines),
+	}
+	if destination, ok := handler.Configs[ConfigDestination].(string); ok && destination != "" {
+		s.destination = destination
+	}
+	if selector, ok := handler.Configs[ConfigSelector].(string); ok && selector != "" {
+		s.headers[HeaderSelector] = selector
+	}
+	if name, ok := handler.Configs[ConfigDurableSubscriptionName].(string); ok && name != "" {
+		// durable subscription identified by (client-id, name) in ActiveMQ and by subscription id in RabbitMQ,
+		// so subscription id must be stable across reconnect and restart
+		s.headers[frame.Id] = name
+		s.headers[HeaderActiveMQSubscriptionName] = name
+		s.headers[HeaderDurableSubscriptionName] = name
+		s.headers[HeaderDurable] = "true"
+		s.headers[HeaderAutoDelete] = "false"
+	}
+	if headers, ok := handler.Configs[ConfigSubscribeHeaders].(map[string]string); ok {
+		for k, v := range headers {
+			s.headers[k] = v
+		}
+	}
+	return s
+}
+
 func (w *workerEngine) getLockKey(eventID string) string {
 	return fmt.Sprintf("%s:stomp-broker-lock:%s", w.service.Name(), eventID)
 }
@@ -218,3 +465,21 @@ func getWorkerTypeLog(name types.Worker) (workerType string) {
 	}
 	return
 }
+
+// deliveryAttempt get delivery attempt (starting from 1) from broker redelivery headers,
+// counted is false if broker has no redelivery counter so attempt is at most 2
+func deliveryAttempt(header *frame.Header) (attempt int, counted bool) {
+	// ActiveMQ Classic redelivery counter
+	if counter, err := strconv.Atoi(header.Get(HeaderRedeliveryCounter)); err == nil {
+		return counter + 1, true
+	}
+	// JMS delivery count (ActiveMQ Artemis)
+	if count, err := strconv.Atoi(header.Get(HeaderJMSXDeliveryCount)); err == nil && count > 0 {
+		return count, true
+	}
+	// broker only flag redelivered message (e.g. RabbitMQ)
+	if header.Get(HeaderRedelivered) == "true" {
+		return 2, false
+	}
+	return 1, false
+}
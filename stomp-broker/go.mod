@@ -6,6 +6,7 @@ require (
 	github.com/go-stomp/stomp/v3 v3.1.0
 	github.com/golangid/candi v1.17.6
 	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.1
 )
 
 require (
@@ -5,4 +5,65 @@ import "github.com/golangid/candi/codebase/factory/types"
 const (
 	// STOMPBroker types
 	STOMPBroker types.Worker = "stomp_broker"
+
+	// ConfigMaxGoroutines handler config key (int), max in-flight messages for handler destination,
+	// worker SetMaxGoroutines option is used if not positive
+	ConfigMaxGoroutines string = "maxGoroutines"
+	// ConfigMaxAttempts handler config key (int), max delivery attempts before failed message forwarded to dead-letter destination
+	ConfigMaxAttempts string = "maxAttempts"
+	// ConfigDeadLetterDestination handler config key (string), destination for message which exceed max attempts
+	ConfigDeadLetterDestination string = "deadLetterDestination"
+	// ConfigDestination handler config key (string), subscribed destination (can be wildcard) if handler pattern is only handler name,
+	// so multiple handlers can subscribe same destination with different selector
+	ConfigDestination string = "destination"
+	// ConfigSelector handler config key (string), JMS message selector (e.g. "region = 'eu'")
+	ConfigSelector string = "selector"
+	// ConfigDurableSubscriptionName handler config key (string), durable topic subscription name,
+	// messages published while consumer is down are kept by broker (connection must have client-id, see DialSetClientID)
+	ConfigDurableSubscriptionName string = "durableSubscriptionName"
+	// ConfigSubscribeHeaders handler config key (map[string]string), additional subscribe headers (broker specific)
+	ConfigSubscribeHeaders string = "subscribeHeaders"
+
+	// HeaderActiveMQPrefetchSize subscribe header for max unacknowledged messages dispatched by ActiveMQ
+	HeaderActiveMQPrefetchSize = "activemq.prefetchSize"
+	// HeaderSelector subscribe header for JMS message selector
+	HeaderSelector = "selector"
+	// HeaderClientID connect header for client id, identify durable subscription owner
+	HeaderClientID = "client-id"
+	// HeaderActiveMQSubscriptionName subscribe header for durable subscription name (ActiveMQ Classic & Artemis)
+	HeaderActiveMQSubscriptionName = "activemq.subscriptionName"
+	// HeaderDurableSubscriptionName subscribe header for durable subscription name (ActiveMQ Artemis)
+	HeaderDurableSubscriptionName = "durable-subscription-name"
+	// HeaderDurable subscribe header for durable subscription (RabbitMQ)
+	HeaderDurable = "durable"
+	// HeaderAutoDelete subscribe header for deleting subscription queue after unsubscribed (RabbitMQ)
+	HeaderAutoDelete = "auto-delete"
+	// HeaderRedeliveryCounter message header for redelivery count (ActiveMQ Classic)
+	HeaderRedeliveryCounter = "redeliveryCounter"
+	// HeaderJMSXDeliveryCount message header for delivery count (ActiveMQ Artemis)
+	HeaderJMSXDeliveryCount = "JMSXDeliveryCount"
+	// HeaderRedelivered message header flag for redelivered message
+	HeaderRedelivered = "redelivered"
+
+	// HeaderPersistent send header for persistent message (bool)
+	HeaderPersistent = "persistent"
+	// HeaderExpires send header for message expiration (epoch millis, or time.Time/time.Duration in publisher argument header)
+	HeaderExpires = "expires"
+	// HeaderPriority send header for message priority (0-9)
+	HeaderPriority = "priority"
+	// HeaderReplyTo send header for reply destination
+	HeaderReplyTo = "reply-to"
+	// HeaderCorrelationID send header for correlating request and reply
+	HeaderCorrelationID = "correlation-id"
+	// HeaderAMQScheduledDelay send header for delayed delivery (millis), set from publisher argument delay
+	HeaderAMQScheduledDelay = "AMQ_SCHEDULED_DELAY"
+
+	// StompErrorHeader header key for handler error in dead-letter message
+	StompErrorHeader = "stompError"
+	// StompOriginalDestinationHeader header key for original destination in dead-letter message
+	StompOriginalDestinationHeader = "stompOriginalDestination"
+	// StompDeliveryAttemptHeader header key for delivery attempt in dead-letter message
+	StompDeliveryAttemptHeader = "stompDeliveryAttempt"
+	// StompRPCErrorHeader header key for error in RPC reply message
+	StompRPCErrorHeader = "stompRPCError"
 )
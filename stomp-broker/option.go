@@ -7,8 +7,9 @@ import (
 
 type (
 	option struct {
-		locker    interfaces.Locker
-		debugMode bool
+		locker        interfaces.Locker
+		debugMode     bool
+		maxGoroutines int
 	}
 
 	// OptionFunc type
@@ -17,8 +18,9 @@ type (
 
 func getDefaultOption() option {
 	return option{
-		debugMode: true,
-		locker:    &candiutils.NoopLocker{},
+		debugMode:     true,
+		locker:        &candiutils.NoopLocker{},
+		maxGoroutines: 1,
 	}
 }
 
@@ -35,3 +37,11 @@ func SetLocker(locker interfaces.Locker) OptionFunc {
 		o.locker = locker
 	}
 }
+
+// SetMaxGoroutines option func, max in-flight messages for each destination (default 1), default is used if not positive,
+// can be overridden per handler with ConfigMaxGoroutines
+func SetMaxGoroutines(maxGoroutines int) OptionFunc {
+	return func(o *option) {
+		o.maxGoroutines = maxGoroutines
+	}
+}
@@ -2,6 +2,9 @@ package stompbroker
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"time"
 
 	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/frame"
@@ -21,27 +24,60 @@ const (
 
 // publisher instance
 type publisher struct {
-	conn *stomp.Conn
+	conn     *connection
+	failFast bool
 }
 
 // NewPublisher constructor
 func NewPublisher(conn *stomp.Conn) interfaces.Publisher {
 	return &publisher{
-		conn: conn,
+		conn: newConnection(conn, nil),
 	}
 }
 
-// PublishMessage method
+// PublishMessage method, join STOMP transaction if context has transaction (see WithTransaction)
 func (s *publisher) PublishMessage(ctx context.Context, args *candishared.PublisherArgument) (err error) {
 	trace, ctx := tracer.StartTraceWithContext(ctx, "StompPublisher:PublishMessage")
 	defer trace.Finish()
 
-	contentType, ok := candishared.GetValueFromContext(ctx, StompContentTypeKey).(string)
-	if !ok {
-		contentType = "text/plain"
+	contentType, message, opts := buildSendFrame(ctx, trace, args)
+
+	if tx := getTransaction(ctx); tx != nil {
+		trace.SetTag("transaction", tx.tx.Id())
+		if err = tx.tx.Send(args.Topic, contentType, message, opts...); err != nil {
+			trace.SetError(err)
+		}
+		return err
+	}
+
+	if s.failFast && !s.conn.isConnected() {
+		trace.SetError(ErrDisconnected)
+		return ErrDisconnected
+	}
+	conn, err := s.conn.get(ctx)
+	if err != nil {
+		trace.SetError(err)
+		return err
+	}
+	err = conn.Send(
+		args.Topic, contentType, message, opts...,
+	)
+	if errors.Is(err, stomp.ErrAlreadyClosed) || errors.Is(err, stomp.ErrClosedUnexpectedly) {
+		s.conn.markBroken(conn)
+	}
+	return err
+}
+
+// buildSendFrame build content type, body and header options for SEND frame from publisher argument
+func buildSendFrame(ctx context.Context, trace tracer.Tracer, args *candishared.PublisherArgument) (contentType string, message []byte, opts []func(*frame.Frame) error) {
+	contentType = args.ContentType
+	if contentType == "" {
+		var ok bool
+		if contentType, ok = candishared.GetValueFromContext(ctx, StompContentTypeKey).(string); !ok {
+			contentType = "text/plain"
+		}
 	}
 
-	var message []byte
 	if len(args.Message) > 0 {
 		message = args.Message
 	} else {
@@ -57,13 +93,30 @@ func (s *publisher) PublishMessage(ctx context.Context, args *candishared.Publis
 		StompEventID: uuid.NewString(),
 	}
 	trace.InjectRequestHeader(header)
+	for k, v := range args.Header {
+		header[k] = headerValue(k, v)
+	}
+	if args.Delay > 0 {
+		header[HeaderAMQScheduledDelay] = strconv.FormatInt(args.Delay.Milliseconds(), 10)
+	}
+	trace.Log("header", header)
 
-	var opts []func(*frame.Frame) error
 	for k, v := range header {
 		opts = append(opts, stomp.SendOpt.Header(k, v))
 	}
+	return contentType, message, opts
+}
 
-	return s.conn.Send(
-		args.Topic, contentType, message, opts...,
-	)
+// headerValue convert publisher argument header value to frame header value,
+// expires header accept time.Time or time.Duration (relative from now) and converted to epoch millis
+func headerValue(key string, value interface{}) string {
+	if key == HeaderExpires {
+		switch v := value.(type) {
+		case time.Time:
+			return strconv.FormatInt(v.UnixMilli(), 10)
+		case time.Duration:
+			return strconv.FormatInt(time.Now().Add(v).UnixMilli(), 10)
+		}
+	}
+	return string(candihelper.ToBytes(value))
 }
@@ -0,0 +1,265 @@
+package stompbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/golangid/candi/candishared"
+	"github.com/golangid/candi/logger"
+	"github.com/golangid/candi/tracer"
+	"github.com/google/uuid"
+)
+
+const (
+	stompMessageKey = candishared.ContextKey("stompMessage")
+
+	tempQueuePrefix = "/temp-queue/"
+)
+
+var (
+	// ErrNoReplyDestination returned when replying message without reply-to header
+	ErrNoReplyDestination = errors.New("stomp: message has no reply-to header")
+)
+
+type (
+	// RPCClientOptionFunc func type
+	RPCClientOptionFunc func(*RPCClient)
+
+	// RPCClient request/reply client, publish request with reply-to and correlation-id header and await the reply
+	RPCClient struct {
+		conn           *connection
+		replyTo        string
+		defaultTimeout time.Duration
+
+		mu      sync.Mutex
+		pending map[string]chan *stomp.Message
+		subConn *stomp.Conn
+		sub     *stomp.Subscription
+
+		closed    chan struct{}
+		closeOnce sync.Once
+	}
+)
+
+// RPCClientSetReplyDestination set reply destination, default is unique temporary queue (RabbitMQ),
+// set queue subscribed by this client only for other brokers (e.g. ActiveMQ)
+func RPCClientSetReplyDestination(destination string) RPCClientOptionFunc {
+	return func(c *RPCClient) {
+		c.replyTo = destination
+	}
+}
+
+// RPCClientSetDefaultTimeout set timeout for awaiting reply when request context has no deadline
+func RPCClientSetDefaultTimeout(timeout time.Duration) RPCClientOptionFunc {
+	return func(c *RPCClient) {
+		c.defaultTimeout = timeout
+	}
+}
+
+// NewRPCClient subscribe to reply destination using broker connection, resubscribe after broker reconnected
+func NewRPCClient(bk *Broker, opts ...RPCClientOptionFunc) (*RPCClient, error) {
+	c := &RPCClient{
+		conn:           bk.conn,
+		replyTo:        tempQueuePrefix + "rpc-" + uuid.NewString(),
+		defaultTimeout: 30 * time.Second,
+		pending:        make(map[string]chan *stomp.Message),
+		closed:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	conn, err := c.conn.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if c.sub, err = c.subscribeReply(conn); err != nil {
+		return nil, err
+	}
+	c.subConn = conn
+
+	go c.receiveReply()
+	return c, nil
+}
+
+// Request publish request message and wait the reply until context deadline,
+// return error if replied with ReplyError
+func (c *RPCClient) Request(ctx context.Context, args *candishared.PublisherArgument) (reply *stomp.Message, err error) {
+	trace, ctx := tracer.StartTraceWithContext(ctx, "StompRPCClient:Request")
+	defer func() { trace.Finish(tracer.FinishWithError(err)) }()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.defaultTimeout)
+		defer cancel()
+	}
+
+	correlationID := uuid.NewString()
+	replyChan := make(chan *stomp.Message, 1)
+	c.mu.Lock()
+	c.pending[correlationID] = replyChan
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, correlationID)
+		c.mu.Unlock()
+	}()
+
+	trace.SetTag("reply_to", c.replyTo)
+	trace.SetTag("correlation_id", correlationID)
+	contentType, message, opts := buildSendFrame(ctx, trace, args)
+	opts = append(opts,
+		stomp.SendOpt.Header(HeaderReplyTo, c.replyTo),
+		stomp.SendOpt.Header(HeaderCorrelationID, correlationID),
+	)
+
+	conn, err := c.conn.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.Send(args.Topic, contentType, message, opts...); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply = <-replyChan:
+		trace.Log("reply", reply.Body)
+		if replyErr := reply.Header.Get(StompRPCErrorHeader); replyErr != "" {
+			return reply, errors.New(replyErr)
+		}
+		return reply, nil
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close unsubscribe reply destination, safe to be called multiple times
+func (c *RPCClient) Close() (err error) {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		err = c.sub.Unsubscribe()
+	})
+	return err
+}
+
+func (c *RPCClient) receiveReply() {
+	for {
+		c.mu.Lock()
+		sub, subConn := c.sub, c.subConn
+		c.mu.Unlock()
+
+		for msg := range sub.C {
+			if msg.Err != nil {
+				break
+			}
+			c.mu.Lock()
+			replyChan, ok := c.pending[msg.Header.Get(HeaderCorrelationID)]
+			c.mu.Unlock()
+			if !ok {
+				continue
+			}
+			select {
+			case replyChan <- msg:
+			default:
+				// duplicate reply (e.g. redelivered request), first reply has been received
+			}
+		}
+
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
+
+		// reply subscription closed by connection lost, resubscribe after reconnected
+		c.conn.markBroken(subConn)
+		if !c.resubscribe() {
+			return
+		}
+	}
+}
+
+// subscribeReply subscribe reply destination, temporary queue is not subscribed to server (rejected by RabbitMQ),
+// server create it on first request and deliver the reply with temporary queue as subscription id
+func (c *RPCClient) subscribeReply(conn *stomp.Conn) (*stomp.Subscription, error) {
+	if strings.HasPrefix(c.replyTo, tempQueuePrefix) {
+		return conn.Subscribe(c.replyTo, stomp.AckAuto, stomp.SubscribeOpt.Header(stomp.ReplyToHeader, c.replyTo))
+	}
+	return conn.Subscribe(c.replyTo, stomp.AckAuto)
+}
+
+func (c *RPCClient) resubscribe() bool {
+	for {
+		conn, err := c.conn.get(context.Background())
+		if err != nil {
+			return false
+		}
+
+		sub, err := c.subscribeReply(conn)
+		if err == nil {
+			c.mu.Lock()
+			c.sub, c.subConn = sub, conn
+			c.mu.Unlock()
+			return true
+		}
+
+		logger.LogRed(fmt.Sprintf("stomp_rpc_client > resubscribe reply destination '%s': %s", c.replyTo, err.Error()))
+		c.conn.markBroken(conn)
+		select {
+		case <-c.closed:
+			return false
+		case <-time.After(reconnectMinBackoff):
+		}
+	}
+}
+
+// Reply send reply message to reply-to destination of consumed request message in worker handler
+func Reply(eventContext *candishared.EventContext, message []byte) error {
+	return reply(eventContext, message, nil)
+}
+
+// ReplyError send error reply to reply-to destination of consumed request message in worker handler,
+// RPCClient.Request return this error
+func ReplyError(eventContext *candishared.EventContext, replyErr error) error {
+	return reply(eventContext, nil, replyErr)
+}
+
+func reply(eventContext *candishared.EventContext, message []byte, replyErr error) (err error) {
+	trace, ctx := tracer.StartTraceWithContext(eventContext.Context(), "StompWorker:Reply")
+	defer func() { trace.Finish(tracer.FinishWithError(err)) }()
+
+	msg, _ := candishared.GetValueFromContext(ctx, stompMessageKey).(*stomp.Message)
+	if msg == nil || msg.Header.Get(HeaderReplyTo) == "" {
+		return ErrNoReplyDestination
+	}
+
+	replyTo := msg.Header.Get(HeaderReplyTo)
+	correlationID := msg.Header.Get(HeaderCorrelationID)
+	trace.SetTag("reply_to", replyTo)
+	trace.SetTag("correlation_id", correlationID)
+	trace.Log("message", message)
+
+	header := map[string]string{
+		StompEventID:        uuid.NewString(),
+		HeaderCorrelationID: correlationID,
+	}
+	if replyErr != nil {
+		header[StompRPCErrorHeader] = replyErr.Error()
+	}
+	trace.InjectRequestHeader(header)
+
+	opts := make([]func(*frame.Frame) error, 0, len(header))
+	for k, v := range header {
+		opts = append(opts, stomp.SendOpt.Header(k, v))
+	}
+	return msg.Conn.Send(replyTo, msg.ContentType, message, opts...)
+}
@@ -0,0 +1,120 @@
+package stompbroker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/golangid/candi/candishared"
+	"github.com/golangid/candi/codebase/interfaces"
+	"github.com/golangid/candi/tracer"
+)
+
+const (
+	stompTransactionKey = candishared.ContextKey("stompTransaction")
+)
+
+var (
+	// ErrTransactionNotSupported returned when publisher is not created by this package
+	ErrTransactionNotSupported = errors.New("stomp: publisher does not support transaction")
+)
+
+// Transaction STOMP transaction, messages sent in transaction are delivered by server on commit
+type Transaction struct {
+	tx *stomp.Transaction
+}
+
+// BeginTransaction start new STOMP transaction from stomp publisher
+func BeginTransaction(ctx context.Context, pub interfaces.Publisher) (*Transaction, error) {
+	trace, ctx := tracer.StartTraceWithContext(ctx, "StompPublisher:BeginTransaction")
+	defer trace.Finish()
+
+	p, ok := pub.(*publisher)
+	if !ok {
+		trace.SetError(ErrTransactionNotSupported)
+		return nil, ErrTransactionNotSupported
+	}
+
+	conn, err := p.conn.get(ctx)
+	if err != nil {
+		trace.SetError(err)
+		return nil, err
+	}
+	tx, err := conn.BeginWithError()
+	if err != nil {
+		trace.SetError(err)
+		return nil, err
+	}
+
+	trace.SetTag("transaction", tx.Id())
+	return &Transaction{tx: tx}, nil
+}
+
+// WithTransaction run fn in STOMP transaction, every PublishMessage using context passed to fn join the transaction.
+// Transaction is committed if fn return nil, otherwise aborted
+func WithTransaction(ctx context.Context, pub interfaces.Publisher, fn func(ctx context.Context) error) (err error) {
+	tx, err := BeginTransaction(ctx, pub)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Abort(ctx)
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx.Context(ctx)); err != nil {
+		tx.Abort(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Context return new context with this transaction, publisher join the transaction when publish using the context
+func (t *Transaction) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stompTransactionKey, t)
+}
+
+// Send publish message in transaction
+func (t *Transaction) Send(ctx context.Context, args *candishared.PublisherArgument) (err error) {
+	trace, ctx := tracer.StartTraceWithContext(ctx, "StompPublisher:SendInTransaction")
+	defer trace.Finish()
+
+	trace.SetTag("transaction", t.tx.Id())
+	contentType, message, opts := buildSendFrame(ctx, trace, args)
+	if err = t.tx.Send(args.Topic, contentType, message, opts...); err != nil {
+		trace.SetError(err)
+	}
+	return err
+}
+
+// Commit transaction
+func (t *Transaction) Commit(ctx context.Context) (err error) {
+	trace, _ := tracer.StartTraceWithContext(ctx, "StompPublisher:CommitTransaction")
+	defer trace.Finish()
+
+	trace.SetTag("transaction", t.tx.Id())
+	if err = t.tx.Commit(); err != nil {
+		trace.SetError(err)
+	}
+	return err
+}
+
+// Abort transaction
+func (t *Transaction) Abort(ctx context.Context) (err error) {
+	trace, _ := tracer.StartTraceWithContext(ctx, "StompPublisher:AbortTransaction")
+	defer trace.Finish()
+
+	trace.SetTag("transaction", t.tx.Id())
+	if err = t.tx.Abort(); err != nil {
+		trace.SetError(err)
+	}
+	return err
+}
+
+func getTransaction(ctx context.Context) *Transaction {
+	tx, _ := candishared.GetValueFromContext(ctx, stompTransactionKey).(*Transaction)
+	return tx
+}